@@ -2,9 +2,11 @@ package uniToken
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -19,7 +21,8 @@ type UniTokenOptions struct {
 	AppName string `json:"appName"`
 	// Description is a brief description of the application
 	Description string `json:"description"`
-	// SavedAPIKey is an optional saved API key, if the user has previously granted permission
+	// SavedAPIKey is an optional saved API key, if the user has previously granted permission.
+	// The service may issue a new key, so always save the latest UniTokenResult.APIKey.
 	SavedAPIKey string `json:"savedApiKey,omitempty"`
 }
 
@@ -33,6 +36,10 @@ type UniTokenResult struct {
 // serviceInfo represents the structure of service.json file
 type serviceInfo struct {
 	URL string `json:"url"`
+	// Socket is a Unix socket only the service's user can connect to
+	Socket string `json:"socket,omitempty"`
+	// Nonce changes on every service start and is echoed by the service
+	Nonce string `json:"nonce,omitempty"`
 }
 
 type appRegisterRequest struct {
@@ -46,7 +53,8 @@ type appRegisterResponse struct {
 }
 
 type uniTokenDetectionResponse struct {
-	UniToken bool `json:"__uni_token"`
+	UniToken bool   `json:"__uni_token"`
+	Nonce    string `json:"nonce"`
 }
 
 // setupServiceRootPath creates and returns the service root directory path
@@ -70,50 +78,73 @@ func setupServiceRootPath() (string, error) {
 	return root, nil
 }
 
-// detectRunningURLFromFile detects if the service is running by reading service.json
-func detectRunningURLFromFile(rootPath string) (string, error) {
+// newServiceClient returns a client that sends requests through the service's
+// Unix socket when it advertises one, and over TCP otherwise
+func newServiceClient(info serviceInfo, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if info.Socket != "" {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", info.Socket)
+			},
+		}
+	}
+	return client
+}
+
+// detectRunningURLFromFile detects if the service is running by reading
+// service.json. The returned client prefers the service's Unix socket.
+func detectRunningURLFromFile(rootPath string) (string, *http.Client, error) {
 	filePath := filepath.Join(rootPath, "service.json")
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return "", nil
+		return "", nil, nil
 	}
 
 	fileContent, err := os.ReadFile(filePath)
 	if err != nil {
-		return "", nil
+		return "", nil, nil
 	}
 
 	var info serviceInfo
 	if err := json.Unmarshal(fileContent, &info); err != nil {
-		return "", nil
+		return "", nil, nil
 	}
 
 	if info.URL == "" {
-		return "", nil
+		return "", nil, nil
 	}
 
-	// Verify the service is actually running
-	client := &http.Client{Timeout: 10 * time.Minute}
+	// Verify the service is actually running, falling back to TCP if the
+	// socket is unusable
+	client := newServiceClient(info, 10*time.Minute)
 	resp, err := client.Get(info.URL)
+	if err != nil && info.Socket != "" {
+		info.Socket = ""
+		client = newServiceClient(info, 10*time.Minute)
+		resp, err = client.Get(info.URL)
+	}
 	if err != nil {
-		return "", nil
+		return "", nil, nil
 	}
 	defer resp.Body.Close()
 
 	var detection uniTokenDetectionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&detection); err != nil {
-		return "", nil
+		return "", nil, nil
 	}
 
-	if detection.UniToken {
-		return info.URL, nil
+	// Services that publish a nonce must echo it, otherwise the file is stale
+	if detection.UniToken && (info.Nonce == "" || detection.Nonce == info.Nonce) {
+		return info.URL, newServiceClient(info, 0), nil
 	}
 
-	return "", nil
+	return "", nil, nil
 }
 
 // startService starts the UniToken service
-func startService(rootPath string) (string, error) {
+func startService(rootPath string) (string, *http.Client, error) {
 	var execPath string
 	if runtime.GOOS == "windows" {
 		execPath = filepath.Join(rootPath, "service.exe")
@@ -123,7 +154,7 @@ func startService(rootPath string) (string, error) {
 
 	if _, err := os.Stat(execPath); os.IsNotExist(err) {
 		if err := downloadService(execPath); err != nil {
-			return "", fmt.Errorf("failed to download service: %w", err)
+			return "", nil, fmt.Errorf("failed to download service: %w", err)
 		}
 	}
 
@@ -132,15 +163,15 @@ func startService(rootPath string) (string, error) {
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to start service: %w", err)
+		return "", nil, fmt.Errorf("failed to start service: %w", err)
 	}
 
-	serverURL, err := detectRunningURLFromFile(rootPath)
+	serverURL, client, err := detectRunningURLFromFile(rootPath)
 	if err != nil || serverURL == "" {
-		return "", fmt.Errorf("service started but URL not detected")
+		return "", nil, fmt.Errorf("service started but URL not detected")
 	}
 
-	return serverURL, nil
+	return serverURL, client, nil
 }
 
 // downloadService downloads the appropriate service binary for the current platform
@@ -188,10 +219,10 @@ func RequestUniTokenOpenAI(options UniTokenOptions) (UniTokenResult, error) {
 	if err != nil {
 		return UniTokenResult{}, fmt.Errorf("failed to setup service root path: %w", err)
 	}
-	serverURL, err := detectRunningURLFromFile(rootPath)
+	serverURL, client, err := detectRunningURLFromFile(rootPath)
 
 	if err != nil || serverURL == "" {
-		serverURL, err = startService(rootPath)
+		serverURL, client, err = startService(rootPath)
 		if err != nil {
 			return UniTokenResult{}, fmt.Errorf("failed to start service: %w", err)
 		}
@@ -208,7 +239,6 @@ func RequestUniTokenOpenAI(options UniTokenOptions) (UniTokenResult, error) {
 		return UniTokenResult{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	client := &http.Client{}
 	resp, err := client.Post(
 		fmt.Sprintf("%sapp/register", serverURL),
 		"application/json",
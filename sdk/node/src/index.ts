@@ -37,7 +37,9 @@ async function detectRunningUrlFromFile(rootPath: string): Promise<string | null
     if (serverUrl) {
       const response = await fetch(serverUrl)
       const data = await response.json()
-      if (data && typeof data === 'object' && '__uni_token' in data) {
+      // Services that publish a nonce must echo it, otherwise the file is stale
+      if (data && typeof data === 'object' && '__uni_token' in data
+        && (!serviceInfo.nonce || data.nonce === serviceInfo.nonce)) {
         return serverUrl
       }
     }
@@ -38,7 +38,11 @@ class UniTokenClient:
 
             self.server_url = service_info.get("url")
 
-            if not self.get("").json().get("__uni_token", None):
+            detection = self.get("").json()
+            if not detection.get("__uni_token", None):
+                self.server_url = None
+            # Services that publish a nonce must echo it, otherwise the file is stale
+            elif service_info.get("nonce") and detection.get("nonce") != service_info["nonce"]:
                 self.server_url = None
         except Exception as _:
             self.server_url = None
@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"uni-token-service/logic"
+	"uni-token-service/store"
+)
+
+// HandleApps runs `service apps <subcommand>`
+func HandleApps(args []string) {
+	defer closeSession()
+
+	if len(args) < 1 {
+		fmt.Println("Usage: apps list | grant <app> [--key <key>] | revoke <app> | set-key <app> <key>")
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		handleAppsList()
+	case "grant":
+		handleAppsGrant(args[1:])
+	case "revoke":
+		handleAppsRevoke(args[1:])
+	case "set-key":
+		handleAppsSetKey(args[1:])
+	default:
+		fmt.Printf("Unknown apps command: %s\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func handleAppsList() {
+	client := session()
+	apps, err := listApps(client)
+	if err != nil {
+		fail(err)
+	}
+	keys, err := listKeys(client)
+	if err != nil {
+		fail(err)
+	}
+	keyNames := map[string]string{}
+	for _, key := range keys {
+		keyNames[key.ID] = key.Name
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tGRANTED\tKEYS\tLAST ACTIVE")
+	for _, app := range apps {
+		var names []string
+		for _, ref := range logic.AppKeyRefs(app) {
+			if name, ok := keyNames[ref.ID]; ok && name != "" {
+				names = append(names, name)
+			} else {
+				names = append(names, ref.ID)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", app.ID, app.Name, app.Granted,
+			strings.Join(names, ", "), app.LastActiveAt.Local().Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+}
+
+// handleAppsGrant grants an app access to the gateway. A registration waiting
+// for approval is answered as if it was granted in the UI.
+func handleAppsGrant(args []string) {
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		fmt.Println("Usage: apps grant <app ID or name> [--key <key ID or name>]")
+		fmt.Println("A key given with --key replaces the app's key pool.")
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("apps grant", flag.ExitOnError)
+	keyRef := flags.String("key", "", "key the app uses")
+	flags.Parse(args[1:])
+
+	client := session()
+	app := mustFindApp(client, args[0])
+	keyID := ""
+	if *keyRef != "" {
+		keyID = mustFindKey(client, *keyRef).ID
+	}
+
+	if client == nil {
+		app.Granted = true
+		if keyID != "" {
+			app.Key = keyID
+			app.Keys = nil
+		}
+		if err := store.Apps.Put(app.ID, app); err != nil {
+			fail(err)
+		}
+	} else {
+		err := client.do(http.MethodPost, "/app/grant", nil, map[string]interface{}{
+			"id":      app.ID,
+			"granted": true,
+			"key":     keyID,
+		}, nil)
+		if err != nil {
+			fail(err)
+		}
+	}
+	fmt.Printf("Granted app %q (%s).\n", app.Name, app.ID)
+	if keyID == "" && len(logic.AppKeyRefs(app)) == 0 {
+		fmt.Println("The app has no key yet, assign one with `apps set-key`.")
+	}
+}
+
+// handleAppsRevoke withdraws the grant of an app, as switching it off in the
+// UI does
+func handleAppsRevoke(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: apps revoke <app ID or name>")
+		os.Exit(2)
+	}
+
+	client := session()
+	app := mustFindApp(client, args[0])
+	app.Granted = false
+	if err := putApp(client, app); err != nil {
+		fail(err)
+	}
+	fmt.Printf("Revoked app %q (%s).\n", app.Name, app.ID)
+}
+
+// handleAppsSetKey makes an app use a single key, replacing its key pool
+func handleAppsSetKey(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: apps set-key <app ID or name> <key ID or name>")
+		os.Exit(2)
+	}
+
+	client := session()
+	app := mustFindApp(client, args[0])
+	key := mustFindKey(client, args[1])
+	app.Key = key.ID
+	app.Keys = nil
+	if err := putApp(client, app); err != nil {
+		fail(err)
+	}
+	fmt.Printf("App %q (%s) now uses key %s.\n", app.Name, app.ID, key.ID)
+}
+
+func mustFindApp(client *Client, ref string) store.AppInfo {
+	apps, err := listApps(client)
+	if err != nil {
+		fail(err)
+	}
+	app, err := findApp(apps, ref)
+	if err != nil {
+		fail(err)
+	}
+	return app
+}
+
+func mustFindKey(client *Client, ref string) store.LLMKey {
+	keys, err := listKeys(client)
+	if err != nil {
+		fail(err)
+	}
+	key, err := findKey(keys, ref)
+	if err != nil {
+		fail(err)
+	}
+	return key
+}
@@ -0,0 +1,256 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"uni-token-service/discovery"
+	"uni-token-service/logic"
+	"uni-token-service/store"
+)
+
+// Client talks to a running service as a logged-in user
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// connect logs in to the running service, or returns nil when no service is
+// running and the command should work on data.db directly
+func connect() (*Client, error) {
+	info, ok := discovery.GetRunningService()
+	if !ok {
+		return nil, nil
+	}
+
+	username, password, err := readCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return login(info, username, password)
+}
+
+// login opens a session on the running service
+func login(info discovery.ServiceInfo, username, password string) (*Client, error) {
+	client := &Client{
+		baseURL: strings.TrimSuffix(info.URL, "/"),
+		http:    discovery.NewServiceClient(info, 0),
+	}
+
+	var auth struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Token   string `json:"token"`
+	}
+	err := client.do(http.MethodPost, "/auth/login", nil, map[string]string{
+		"username": username,
+		"password": password,
+	}, &auth)
+	if err != nil {
+		return nil, err
+	}
+	if auth.Status != "success" {
+		if auth.Message == "" {
+			auth.Message = auth.Status
+		}
+		return nil, fmt.Errorf("login failed: %s", auth.Message)
+	}
+	client.token = auth.Token
+	cleanups = append(cleanups, client.logout)
+	return client, nil
+}
+
+// logout ends the session, so commands do not leave live tokens behind
+func (c *Client) logout() {
+	if c.token == "" {
+		return
+	}
+	c.do(http.MethodPost, "/auth/logout", nil, nil, nil)
+	c.token = ""
+}
+
+// readCredentials takes the login from UNI_TOKEN_USERNAME and
+// UNI_TOKEN_PASSWORD, prompting for whatever is missing
+func readCredentials() (string, string, error) {
+	username := os.Getenv("UNI_TOKEN_USERNAME")
+	if username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", "", fmt.Errorf("failed to read username: %w", err)
+		}
+		username = strings.TrimSpace(line)
+	}
+	password, err := readSecret("UNI_TOKEN_PASSWORD", "Password")
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// readSecret takes a secret from an environment variable, or prompts for it
+// without echoing it
+func readSecret(env, prompt string) (string, error) {
+	if value := os.Getenv(env); value != "" {
+		return value, nil
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", strings.ToLower(prompt), err)
+	}
+	return string(secret), nil
+}
+
+// stream sends a request and returns the raw response body on success
+func (c *Client) stream(method, path string, query url.Values, body interface{}) (io.ReadCloser, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		// Auth endpoints report errors as a message
+		var apiErr struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s", apiErr.Error)
+		}
+		if apiErr.Message != "" {
+			return nil, fmt.Errorf("%s", apiErr.Message)
+		}
+		return nil, fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// do sends a request and decodes the JSON response into out
+func (c *Client) do(method, path string, query url.Values, body, out interface{}) error {
+	respBody, err := c.stream(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer respBody.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(respBody).Decode(out)
+}
+
+// cleanups run when a command ends, including through fail
+var cleanups []func()
+
+// closeSession logs out of the service, or closes and unlocks data.db
+func closeSession() {
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	cleanups = nil
+}
+
+// openStore opens data.db for commands run while the service is stopped. The
+// instance lock is held until the command ends, so no service starts on the
+// file meanwhile.
+func openStore() {
+	lock, err := discovery.AcquireInstanceLock()
+	if errors.Is(err, discovery.ErrAlreadyRunning) {
+		fail(fmt.Errorf("%s is in use by a service that does not respond, see `service doctor`", discovery.GetDbPath()))
+	}
+	if err != nil {
+		fail(err)
+	}
+	cleanups = append(cleanups, func() { lock.Release() })
+
+	store.Init(discovery.GetDbPath())
+	cleanups = append(cleanups, func() { store.Close() })
+}
+
+// session connects to the running service, or opens data.db when it is
+// stopped, in which case the returned client is nil
+func session() *Client {
+	client, err := connect()
+	if err != nil {
+		fail(err)
+	}
+	if client == nil {
+		openStore()
+	}
+	return client
+}
+
+// unlockKeys makes provider tokens readable when data.db is opened directly
+// and they are protected by the owner's password
+func unlockKeys() error {
+	if _, locked, err := store.GetKeyProtection(); err != nil || !locked {
+		return err
+	}
+	username, password, err := readCredentials()
+	if err != nil {
+		return err
+	}
+	user, err := store.Users.Get(username)
+	if err != nil {
+		return errors.New("invalid username or password")
+	}
+	if ok, _ := logic.VerifyPassword(password, user.Password); !ok {
+		return errors.New("invalid username or password")
+	}
+	if err := store.UnlockKeyVault(username, password); err != nil {
+		return err
+	}
+	// Logins by anyone but the owner leave the vault locked
+	if _, locked, _ := store.GetKeyProtection(); locked {
+		return store.ErrKeysLocked
+	}
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	exit(1)
+}
+
+// exit closes the session first, as os.Exit skips deferred calls
+func exit(code int) {
+	closeSession()
+	os.Exit(code)
+}
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"uni-token-service/discovery"
+	"uni-token-service/logic"
+	"uni-token-service/logic/open_browser"
+	"uni-token-service/logic/url_scheme"
+)
+
+const reachabilityTimeout = 5 * time.Second
+
+// HandleDoctor runs `service doctor`, which checks the environment the service
+// depends on and prints a fix for every problem found
+func HandleDoctor(args []string) {
+	defer closeSession()
+
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	flags.Parse(args)
+
+	info, running := discovery.GetRunningService()
+	failed := 0
+	report := func(name string, err error, fix string) {
+		if err == nil {
+			fmt.Printf("[ok]   %s\n", name)
+			return
+		}
+		failed++
+		fmt.Printf("[fail] %s: %v\n", name, err)
+		if fix != "" {
+			fmt.Printf("       Fix: %s\n", fix)
+		}
+	}
+
+	report("Port range", checkPortRange(info, running),
+		fmt.Sprintf("stop the programs listening on ports %d-%d, or free at least one port of the range", logic.PortRangeStart, logic.PortRangeEnd-1))
+
+	report("URL scheme", urlScheme.CheckURLScheme(urlScheme.UrlSchemeRegisterOption{
+		Scheme:         "uni-token",
+		AppName:        "UniToken",
+		ExecutablePath: discovery.GetServiceExecutablePath(),
+	}), "run `service setup` to register uni-token:// again")
+
+	browser, err := openBrowser.FindBrowser()
+	if err == nil {
+		fmt.Printf("[ok]   Browser (%s)\n", browser)
+	} else {
+		report("Browser", err, "install xdg-utils, or open the URL printed by the service manually")
+	}
+
+	report("Database lock", checkDatabaseLock(running),
+		"stop the process holding the lock, its PID is in "+discovery.GetLockPath())
+
+	for _, endpoint := range keyEndpoints(info, running) {
+		report(fmt.Sprintf("Key %q (%s)", endpoint.Name, endpoint.BaseURL), checkReachable(endpoint.BaseURL),
+			"check the base URL of the key, and the network or proxy settings of this machine")
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d check(s) failed.\n", failed)
+		exit(1)
+	}
+	fmt.Println("\nAll checks passed.")
+}
+
+// checkPortRange needs one port of the range to be free on every loopback
+// address, unless the service is already listening on one
+func checkPortRange(info discovery.ServiceInfo, running bool) error {
+	if running {
+		return nil
+	}
+	addresses := logic.UsableAddresses(logic.LoopbackAddresses)
+	for port := logic.PortRangeStart; port < logic.PortRangeEnd; port++ {
+		available := true
+		for _, address := range addresses {
+			if !logic.IsPortAvailable(address, port) {
+				available = false
+				break
+			}
+		}
+		if available {
+			return nil
+		}
+	}
+	return fmt.Errorf("all ports %d-%d are in use", logic.PortRangeStart, logic.PortRangeEnd-1)
+}
+
+// checkDatabaseLock detects a service that holds the instance lock or the
+// database without answering, which blocks new instances from starting
+func checkDatabaseLock(running bool) error {
+	if running {
+		return nil
+	}
+
+	if discovery.IsInstanceLocked() {
+		if pid := readLockPID(); pid != 0 {
+			return fmt.Errorf("the instance lock is held by PID %d, which does not respond", pid)
+		}
+		return errors.New("the instance lock is held by a service that does not respond")
+	}
+
+	dbPath := discovery.GetDbPath()
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: time.Second, ReadOnly: true})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return fmt.Errorf("%s is locked by another process", dbPath)
+	}
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// keyEndpoints lists the base URLs of the stored keys, from the running
+// service when there is one
+func keyEndpoints(info discovery.ServiceInfo, running bool) []logic.KeyEndpoint {
+	if running {
+		status, err := fetchStatus(info)
+		if err != nil {
+			fmt.Printf("[skip] Keys: %v\n", err)
+			return nil
+		}
+		return status.KeyEndpoints
+	}
+
+	if _, err := os.Stat(discovery.GetDbPath()); os.IsNotExist(err) {
+		return nil
+	}
+	if discovery.IsInstanceLocked() {
+		fmt.Println("[skip] Keys: the database is in use")
+		return nil
+	}
+
+	status, err := logic.CollectOfflineStatus(discovery.GetDbPath())
+	if err != nil {
+		fmt.Printf("[skip] Keys: %v\n", err)
+		return nil
+	}
+	return status.KeyEndpoints
+}
+
+// checkReachable treats any HTTP response as reachable, the key check in the
+// UI covers authentication
+func checkReachable(baseURL string) error {
+	client := &http.Client{Timeout: reachabilityTimeout}
+	resp, err := client.Get(baseURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+
+	"uni-token-service/logic"
+	"uni-token-service/store"
+)
+
+// HandleKeys runs `service keys <subcommand>`
+func HandleKeys(args []string) {
+	defer closeSession()
+
+	if len(args) < 1 {
+		fmt.Println("Usage: keys list | add [options] | rm <key> | test <key>")
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		handleKeysList()
+	case "add":
+		handleKeysAdd(args[1:])
+	case "rm":
+		handleKeysRemove(args[1:])
+	case "test":
+		handleKeysTest(args[1:])
+	default:
+		fmt.Printf("Unknown keys command: %s\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func handleKeysList() {
+	client := session()
+	keys, err := listKeys(client)
+	if err != nil {
+		fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPROTOCOL\tBASE URL\tMODELS")
+	for _, key := range keys {
+		models := "untested"
+		if key.ModelsUpdatedAt != nil {
+			models = fmt.Sprint(len(key.Models))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, logic.KeyProtocol(key), key.BaseURL, models)
+	}
+	w.Flush()
+}
+
+// handleKeysAdd stores a new key. The token is read from UNI_TOKEN_KEY_TOKEN
+// or prompted for, so it does not end up in the shell history.
+func handleKeysAdd(args []string) {
+	flags := flag.NewFlagSet("keys add", flag.ExitOnError)
+	name := flags.String("name", "", "display name of the key")
+	baseURL := flags.String("base-url", "", "API base URL of the provider (required)")
+	protocol := flags.String("protocol", logic.ProtocolOpenAI, "wire protocol: openai or anthropic")
+	noTest := flags.Bool("no-test", false, "do not check the key after adding it")
+	flags.Parse(args)
+
+	if *baseURL == "" {
+		fail(fmt.Errorf("--base-url is required"))
+	}
+	if parsed, err := url.Parse(*baseURL); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		fail(fmt.Errorf("invalid --base-url %q", *baseURL))
+	}
+	if *protocol != logic.ProtocolOpenAI && *protocol != logic.ProtocolAnthropic {
+		fail(fmt.Errorf(`--protocol must be "openai" or "anthropic"`))
+	}
+
+	client := session()
+	if client == nil {
+		if err := unlockKeys(); err != nil {
+			fail(err)
+		}
+	}
+	token, err := readSecret("UNI_TOKEN_KEY_TOKEN", "Token")
+	if err != nil {
+		fail(err)
+	}
+	if token == "" {
+		fail(fmt.Errorf("the token must not be empty"))
+	}
+
+	key := store.LLMKey{
+		ID:       uuid.NewString(),
+		Name:     *name,
+		Type:     "manual",
+		Protocol: *protocol,
+		BaseURL:  *baseURL,
+		Token:    token,
+	}
+	if err := putKey(client, key); err != nil {
+		fail(err)
+	}
+	fmt.Printf("Added key %s.\n", key.ID)
+
+	if !*noTest {
+		printKeyTest(testKey(client, key.ID))
+	}
+}
+
+func handleKeysRemove(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: keys rm <key ID or name>")
+		os.Exit(2)
+	}
+
+	client := session()
+	key := mustFindKey(client, args[0])
+	err := deleteKey(client, key.ID)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Removed key %s.\n", key.ID)
+
+	// Apps keep their reference, so point out which ones lost a key
+	apps, err := listApps(client)
+	if err != nil {
+		return
+	}
+	for _, app := range apps {
+		if slices.ContainsFunc(logic.AppKeyRefs(app), func(ref store.AppKeyRef) bool { return ref.ID == key.ID }) {
+			fmt.Printf("App %q (%s) used this key, assign another one with `apps set-key`.\n", app.Name, app.ID)
+		}
+	}
+}
+
+func handleKeysTest(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: keys test <key ID or name>")
+		os.Exit(2)
+	}
+
+	client := session()
+	key := mustFindKey(client, args[0])
+	if client == nil {
+		if err := unlockKeys(); err != nil {
+			fail(err)
+		}
+	}
+	if !printKeyTest(testKey(client, key.ID)) {
+		exit(1)
+	}
+}
+
+// testKey checks a key and caches its model list, like the key dialog does
+func testKey(client *Client, keyID string) (logic.KeyCheckResult, error) {
+	if client == nil {
+		return logic.TestKey(keyID)
+	}
+	var resp struct {
+		Data logic.KeyCheckResult `json:"data"`
+	}
+	err := client.do(http.MethodPost, "/keys/"+url.PathEscape(keyID)+"/test", nil, nil, &resp)
+	return resp.Data, err
+}
+
+func printKeyTest(result logic.KeyCheckResult, err error) bool {
+	if err != nil {
+		fmt.Printf("Key check failed: %v\n", err)
+		return false
+	}
+	if !result.OK {
+		fmt.Printf("Key check failed: %s\n", result.Error)
+		return false
+	}
+	fmt.Printf("Key works: %d models available (%d ms).\n", len(result.Models), result.LatencyMs)
+	return true
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"uni-token-service/constants"
+	"uni-token-service/discovery"
+	"uni-token-service/logic"
+)
+
+// HandleStatus runs `service status`, which describes the installed service,
+// whether it is running and what its database holds
+func HandleStatus(args []string) {
+	defer closeSession()
+
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.Parse(args)
+
+	execPath := discovery.GetServiceExecutablePath()
+	fmt.Printf("Executable:     %s (%s)\n", execPath, installedState(execPath))
+	fmt.Printf("Version:        %d\n", constants.Version)
+	fmt.Printf("Service root:   %s\n", discovery.GetServiceRootPath())
+
+	info, running := discovery.GetRunningService()
+	if raw, err := os.ReadFile(discovery.GetServiceJsonPath()); err == nil {
+		fmt.Printf("Discovery file: %s\n", discovery.GetServiceJsonPath())
+		fmt.Printf("%s\n", indent(strings.TrimSpace(string(raw)), "  "))
+	} else {
+		fmt.Printf("Discovery file: %s (missing)\n", discovery.GetServiceJsonPath())
+	}
+	if lockPID := readLockPID(); lockPID != 0 {
+		fmt.Printf("Lock file:      %s (last held by PID %d)\n", discovery.GetLockPath(), lockPID)
+	}
+
+	if !running {
+		fmt.Println("State:          stopped")
+		fmt.Println()
+		printOfflineStatus()
+		return
+	}
+
+	fmt.Println("State:          running")
+	fmt.Printf("PID:            %d\n", info.PID)
+	fmt.Printf("URL:            %s\n", info.URL)
+	if info.Socket != "" {
+		fmt.Printf("Socket:         %s\n", info.Socket)
+	}
+	fmt.Println()
+
+	status, err := fetchStatus(info)
+	if errors.Is(err, errServiceStopped) {
+		fmt.Println("The service stopped while being asked for its status.")
+		fmt.Println()
+		printOfflineStatus()
+		return
+	}
+	if err != nil {
+		fail(err)
+	}
+	printStatus(status)
+}
+
+var errServiceStopped = errors.New("service is not running")
+
+// fetchStatus asks the running service for its status. Over the Unix socket
+// no login is needed.
+func fetchStatus(info discovery.ServiceInfo) (logic.ServiceStatus, error) {
+	var status logic.ServiceStatus
+	client := &Client{
+		baseURL: strings.TrimSuffix(info.URL, "/"),
+		http:    discovery.NewServiceClient(info, 10*time.Second),
+	}
+	if info.Socket == "" {
+		var err error
+		if client, err = connect(); err != nil {
+			return status, err
+		}
+		if client == nil {
+			return status, errServiceStopped
+		}
+	}
+	return status, client.do(http.MethodGet, "/status", nil, nil, &status)
+}
+
+// printOfflineStatus reads data.db directly, read-only. It is left alone when
+// another process holds it, which means a service is running but not
+// answering.
+func printOfflineStatus() {
+	if _, err := os.Stat(discovery.GetDbPath()); os.IsNotExist(err) {
+		fmt.Printf("Database:       %s (missing)\n", discovery.GetDbPath())
+		return
+	}
+	if discovery.IsInstanceLocked() {
+		fmt.Printf("Database:       %s (in use by a service that does not respond, see `service doctor`)\n", discovery.GetDbPath())
+		return
+	}
+
+	status, err := logic.CollectOfflineStatus(discovery.GetDbPath())
+	if err != nil {
+		fail(err)
+	}
+	printStatus(status)
+}
+
+func printStatus(status logic.ServiceStatus) {
+	fmt.Printf("Database:       %s (%s)\n", status.DbPath, formatSize(status.DbSize))
+	if status.Port > 0 {
+		fmt.Printf("Port:           %d\n", status.Port)
+	}
+	if status.Version != constants.Version {
+		fmt.Printf("Service version: %d (differs from this executable)\n", status.Version)
+	}
+	fmt.Printf("Users:          %d\n", status.Users)
+	fmt.Printf("Apps:           %d (%d granted)\n", status.Apps, status.GrantedApps)
+	fmt.Printf("Keys:           %d\n", status.Keys)
+}
+
+func installedState(execPath string) string {
+	if _, err := os.Stat(execPath); err != nil {
+		return "not installed"
+	}
+	self, err := os.Executable()
+	if err == nil && self == execPath {
+		return "installed, running this copy"
+	}
+	return "installed"
+}
+
+func readLockPID() int {
+	data, err := os.ReadFile(discovery.GetLockPath())
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+func indent(text, prefix string) string {
+	return prefix + strings.ReplaceAll(text, "\n", "\n"+prefix)
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"uni-token-service/store"
+)
+
+// listBucket reads a whole bucket through the generic store API of the
+// running service
+func listBucket[T any](client *Client, bucket string) ([]T, error) {
+	var raw map[string]string
+	if err := client.do(http.MethodGet, "/store/"+bucket, nil, nil, &raw); err != nil {
+		return nil, err
+	}
+	items := make([]T, 0, len(raw))
+	for _, value := range raw {
+		var item T
+		if err := json.Unmarshal([]byte(value), &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func putBucket(client *Client, bucket, id string, value interface{}) error {
+	return client.do(http.MethodPut, "/store/"+bucket+"/"+url.PathEscape(id), nil, value, nil)
+}
+
+func deleteBucket(client *Client, bucket, id string) error {
+	return client.do(http.MethodDelete, "/store/"+bucket+"/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// listKeys returns the stored keys sorted by name, from the running service
+// when there is a client and from data.db otherwise
+func listKeys(client *Client) ([]store.LLMKey, error) {
+	var keys []store.LLMKey
+	var err error
+	if client == nil {
+		keys, err = store.LLMKeys.List()
+	} else {
+		keys, err = listBucket[store.LLMKey](client, "llm_keys")
+	}
+	slices.SortFunc(keys, func(a, b store.LLMKey) int {
+		return strings.Compare(a.Name+a.ID, b.Name+b.ID)
+	})
+	return keys, err
+}
+
+func putKey(client *Client, key store.LLMKey) error {
+	if client == nil {
+		if err := store.SealLLMKey(&key); err != nil {
+			return err
+		}
+		return store.LLMKeys.Put(key.ID, key)
+	}
+	return putBucket(client, "llm_keys", key.ID, key)
+}
+
+func deleteKey(client *Client, id string) error {
+	if client == nil {
+		return store.LLMKeys.Delete(id)
+	}
+	return deleteBucket(client, "llm_keys", id)
+}
+
+// findKey looks a key up by ID, or by name when the name is unique
+func findKey(keys []store.LLMKey, ref string) (store.LLMKey, error) {
+	var matches []store.LLMKey
+	for _, key := range keys {
+		if key.ID == ref {
+			return key, nil
+		}
+		if key.Name == ref {
+			matches = append(matches, key)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return store.LLMKey{}, fmt.Errorf("key %q not found", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return store.LLMKey{}, fmt.Errorf("%d keys are named %q, use the key ID", len(matches), ref)
+	}
+}
+
+// listApps returns the registered apps sorted by name
+func listApps(client *Client) ([]store.AppInfo, error) {
+	var apps []store.AppInfo
+	var err error
+	if client == nil {
+		apps, err = store.Apps.List()
+	} else {
+		apps, err = listBucket[store.AppInfo](client, "apps")
+	}
+	slices.SortFunc(apps, func(a, b store.AppInfo) int {
+		return strings.Compare(a.Name+a.ID, b.Name+b.ID)
+	})
+	return apps, err
+}
+
+func putApp(client *Client, app store.AppInfo) error {
+	if client == nil {
+		return store.Apps.Put(app.ID, app)
+	}
+	return putBucket(client, "apps", app.ID, app)
+}
+
+// findApp looks an app up by ID, or by name when the name is unique
+func findApp(apps []store.AppInfo, ref string) (store.AppInfo, error) {
+	var matches []store.AppInfo
+	for _, app := range apps {
+		if app.ID == ref {
+			return app, nil
+		}
+		if app.Name == ref {
+			matches = append(matches, app)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return store.AppInfo{}, fmt.Errorf("app %q not found", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return store.AppInfo{}, fmt.Errorf("%d apps are named %q, use the app ID", len(matches), ref)
+	}
+}
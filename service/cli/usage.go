@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"uni-token-service/logic"
+	"uni-token-service/store"
+)
+
+// HandleUsage runs `service usage <subcommand>`
+func HandleUsage(args []string) {
+	defer closeSession()
+
+	if len(args) < 1 {
+		fmt.Println("Usage: usage export [options]")
+		return
+	}
+
+	switch args[0] {
+	case "export":
+		handleUsageExport(args[1:])
+	default:
+		fmt.Printf("Unknown usage command: %s\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// handleUsageExport writes usage records as CSV or JSON Lines. It goes
+// through the API when the service is running, and reads data.db otherwise.
+func handleUsageExport(args []string) {
+	flags := flag.NewFlagSet("usage export", flag.ExitOnError)
+	format := flags.String("format", logic.ExportFormatCSV, "output format: csv or jsonl")
+	groupBy := flags.String("group-by", "", `set to "day" to aggregate records per day`)
+	appID := flags.String("app", "", "only records of this app ID")
+	key := flags.String("key", "", "only records of this key ID or name")
+	model := flags.String("model", "", "only records of this model")
+	status := flags.String("status", "", "only records with this status")
+	endpoint := flags.String("endpoint", "", "only records of this endpoint")
+	from := flags.String("from", "", "start time, RFC 3339")
+	to := flags.String("to", "", "end time, RFC 3339")
+	output := flags.String("output", "", "output file, stdout by default")
+	flags.Parse(args)
+
+	if err := logic.ValidateExportFormat(*format); err != nil {
+		fail(err)
+	}
+	if *groupBy != "" && *groupBy != store.RollupDaily {
+		fail(fmt.Errorf(`--group-by must be empty or "day"`))
+	}
+
+	filter := store.UsageFilter{
+		AppID:    *appID,
+		Key:      *key,
+		Model:    *model,
+		Status:   *status,
+		Endpoint: *endpoint,
+	}
+	var err error
+	if *from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			fail(fmt.Errorf("invalid --from time: %w", err))
+		}
+	}
+	if *to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			fail(fmt.Errorf("invalid --to time: %w", err))
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fail(err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	client, err := connect()
+	if err != nil {
+		fail(err)
+	}
+
+	if client == nil {
+		openStore()
+		err = logic.ExportUsage(w, filter, *format, *groupBy == store.RollupDaily)
+	} else {
+		query := url.Values{}
+		query.Set("format", *format)
+		setQuery(query, "groupBy", *groupBy)
+		setQuery(query, "appId", *appID)
+		setQuery(query, "key", *key)
+		setQuery(query, "model", *model)
+		setQuery(query, "status", *status)
+		setQuery(query, "endpoint", *endpoint)
+		setQuery(query, "from", *from)
+		setQuery(query, "to", *to)
+
+		var body io.ReadCloser
+		body, err = client.stream(http.MethodGet, "/usage/export", query, nil)
+		if err == nil {
+			_, err = io.Copy(w, body)
+			body.Close()
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func setQuery(query url.Values, name, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
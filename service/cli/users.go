@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"uni-token-service/discovery"
+	"uni-token-service/logic"
+	"uni-token-service/store"
+)
+
+// HandleUsers runs `service users <subcommand>`
+func HandleUsers(args []string) {
+	defer closeSession()
+
+	if len(args) < 1 {
+		fmt.Println("Usage: users passwd")
+		return
+	}
+
+	switch args[0] {
+	case "passwd":
+		handleUsersPasswd()
+	default:
+		fmt.Printf("Unknown users command: %s\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// handleUsersPasswd changes a user's password. The new password is read from
+// UNI_TOKEN_NEW_PASSWORD or prompted for twice.
+func handleUsersPasswd() {
+	username, oldPassword, err := readCredentials()
+	if err != nil {
+		fail(err)
+	}
+	newPassword, err := readNewPassword()
+	if err != nil {
+		fail(err)
+	}
+
+	if info, ok := discovery.GetRunningService(); ok {
+		client, err := login(info, username, oldPassword)
+		if err != nil {
+			fail(err)
+		}
+		var changed struct {
+			Token string `json:"token"`
+		}
+		err = client.do(http.MethodPost, "/auth/password", nil, map[string]string{
+			"oldPassword": oldPassword,
+			"newPassword": newPassword,
+		}, &changed)
+		if err != nil {
+			fail(err)
+		}
+		// The change ended the old session, log out of the one it returned
+		client.token = changed.Token
+	} else {
+		openStore()
+		if err := changePassword(username, oldPassword, newPassword); err != nil {
+			fail(err)
+		}
+	}
+	fmt.Printf("Changed the password of %s.\n", username)
+}
+
+func readNewPassword() (string, error) {
+	if password := os.Getenv("UNI_TOKEN_NEW_PASSWORD"); password != "" {
+		return password, nil
+	}
+	password, err := readSecret("", "New password")
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("the new password must not be empty")
+	}
+	repeated, err := readSecret("", "Repeat new password")
+	if err != nil {
+		return "", err
+	}
+	if repeated != password {
+		return "", fmt.Errorf("the passwords do not match")
+	}
+	return password, nil
+}
+
+// changePassword does what /auth/password does, on data.db directly
+func changePassword(username, oldPassword, newPassword string) error {
+	if err := logic.ChangePassword(username, oldPassword, newPassword); err != nil {
+		return err
+	}
+	return store.RecordAuthEvent(store.AuthEvent{
+		Type:       store.AuthEventPasswordChange,
+		Username:   username,
+		RemoteAddr: "cli",
+	})
+}
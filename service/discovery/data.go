@@ -1,6 +1,8 @@
 package discovery
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -8,13 +10,30 @@ import (
 )
 
 type ServiceInfo struct {
-	Command   []string `json:"command"`
-	PID       int      `json:"pid"`
-	URL       string   `json:"url"`
-	Timestamp int64    `json:"timestamp"`
+	Command []string `json:"command"`
+	PID     int      `json:"pid"`
+	URL     string   `json:"url"`
+	// Socket is a Unix socket serving the same API, reachable only by the
+	// user running the service. Empty if it could not be created.
+	Socket string `json:"socket,omitempty"`
+	// Nonce is generated on every start and echoed by the service, so clients
+	// can tell it apart from whatever else answers on a reused port
+	Nonce     string `json:"nonce,omitempty"`
+	Timestamp int64  `json:"timestamp"`
 }
 
-func GetServiceInfo(port *int) string {
+// BootNonce identifies this run of the service
+var BootNonce = newBootNonce()
+
+func newBootNonce() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(nonce)
+}
+
+func GetServiceInfo(port *int, socket string) string {
 	var url string
 	if port != nil {
 		url = fmt.Sprintf("http://localhost:%d/", *port)
@@ -24,6 +43,8 @@ func GetServiceInfo(port *int) string {
 		Command:   os.Args,
 		PID:       os.Getpid(),
 		URL:       url,
+		Socket:    socket,
+		Nonce:     BootNonce,
 		Timestamp: time.Now().UnixMilli(),
 	}
 
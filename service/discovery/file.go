@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -29,10 +30,14 @@ func GetServiceExecutablePath() string {
 	}
 }
 
-func getServiceJsonPath() string {
+func GetServiceJsonPath() string {
 	return filepath.Join(GetServiceRootPath(), "service.json")
 }
 
+func GetServiceSocketPath() string {
+	return filepath.Join(GetServiceRootPath(), "service.sock")
+}
+
 func GetDbPath() string {
 	if os.Args[1] == "debug" {
 		return "./data.db"
@@ -40,16 +45,16 @@ func GetDbPath() string {
 	return filepath.Join(GetServiceRootPath(), "data.db")
 }
 
-func SetupFileDiscovery(port int) error {
-	filePath := getServiceJsonPath()
+func SetupFileDiscovery(port int, socket string) error {
+	filePath := GetServiceJsonPath()
 
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return err
 	}
 
-	// Write initial service data
-	if err := os.WriteFile(filePath, []byte(GetServiceInfo(&port)), 0644); err != nil {
+	// Write initial service data, readable only by the service's user
+	if err := writeFileAtomic(filePath, []byte(GetServiceInfo(&port, socket)), 0600); err != nil {
 		return err
 	}
 
@@ -57,3 +62,52 @@ func SetupFileDiscovery(port int) error {
 
 	return nil
 }
+
+// RemoveFileDiscovery removes service.json on shutdown, unless another
+// instance has replaced it in the meantime
+func RemoveFileDiscovery() error {
+	filePath := GetServiceJsonPath()
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var info ServiceInfo
+	if err := json.Unmarshal(fileContent, &info); err != nil {
+		return err
+	}
+	if info.PID != os.Getpid() || info.Nonce != BootNonce {
+		return nil
+	}
+	return os.Remove(filePath)
+}
+
+// writeFileAtomic replaces a file in one step, so readers never see it
+// half-written
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var ErrAlreadyRunning = errors.New("another instance of the service is already running")
+
+// InstanceLock keeps a second copy of the service for the same user from
+// starting and competing for the database and the discovery file. The lock
+// is released by the OS if the process dies.
+type InstanceLock struct {
+	file *os.File
+}
+
+func GetLockPath() string {
+	return filepath.Join(GetServiceRootPath(), "service.lock")
+}
+
+// AcquireInstanceLock takes the single-instance lock, or fails with
+// ErrAlreadyRunning when another instance holds it
+func AcquireInstanceLock() (*InstanceLock, error) {
+	path := GetLockPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w (%s)", ErrAlreadyRunning, path)
+	}
+
+	// Record the owner for diagnostics, the lock itself is what matters
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	return &InstanceLock{file: file}, nil
+}
+
+// Release gives up the lock. The file is kept, removing it would race with
+// another instance acquiring it.
+func (l *InstanceLock) Release() error {
+	return l.file.Close()
+}
+
+// IsInstanceLocked reports whether a service holds the lock, without taking
+// it over as AcquireInstanceLock would
+func IsInstanceLocked() bool {
+	file, err := os.OpenFile(GetLockPath(), os.O_RDWR, 0644)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return lockFile(file) != nil
+}
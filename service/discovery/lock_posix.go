@@ -0,0 +1,20 @@
+//go:build !windows
+
+package discovery
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func lockFile(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+}
+
+// IsProcessAlive reports whether a process exists, including processes of
+// other users that cannot be signaled
+func IsProcessAlive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
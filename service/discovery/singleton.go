@@ -1,49 +1,82 @@
 package discovery
 
 import (
+	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"os"
 	"time"
 )
 
 type uniTokenDetectionResponse struct {
-	UniToken bool `json:"__uni_token"`
+	UniToken bool   `json:"__uni_token"`
+	Nonce    string `json:"nonce"`
 }
 
 func IsServiceRunning() bool {
-	filePath := getServiceJsonPath()
+	_, ok := GetRunningService()
+	return ok
+}
+
+// GetRunningService returns the info advertised in service.json if the
+// service that wrote it is still alive and answering there
+func GetRunningService() (ServiceInfo, bool) {
+	var info ServiceInfo
+	filePath := GetServiceJsonPath()
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return false
+		return info, false
 	}
 
 	fileContent, err := os.ReadFile(filePath)
 	if err != nil {
-		return false
+		return info, false
 	}
 
-	var info ServiceInfo
 	if err := json.Unmarshal(fileContent, &info); err != nil {
-		return false
+		return info, false
 	}
 
 	if info.URL == "" {
-		return false
+		return info, false
+	}
+
+	// A file left behind by a crashed service is stale, whatever answers now
+	if info.PID != 0 && !IsProcessAlive(info.PID) {
+		return info, false
 	}
 
 	// Verify the service is actually running
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := NewServiceClient(info, 5*time.Second)
 	resp, err := client.Get(info.URL)
 	if err != nil {
-		return false
+		return info, false
 	}
 	defer resp.Body.Close()
 
 	var detection uniTokenDetectionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&detection); err != nil {
-		return false
+		return info, false
 	}
 
-	return detection.UniToken
+	if info.Nonce != "" && detection.Nonce != info.Nonce {
+		return info, false
+	}
+	return info, detection.UniToken
+}
+
+// NewServiceClient returns a client for the service API. Requests go through
+// the Unix socket when the service advertises one, whatever the URL host.
+func NewServiceClient(info ServiceInfo, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if info.Socket != "" {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", info.Socket)
+			},
+		}
+	}
+	return client
 }
@@ -0,0 +1,141 @@
+package logic
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"uni-token-service/store"
+)
+
+// App tokens look like "uta_<app ID>.<secret>". Only a hash of the secret is
+// stored, so a leaked database or app ID does not grant gateway access.
+const appTokenPrefix = "uta_"
+
+var (
+	ErrInvalidAppToken     = errors.New("invalid app token")
+	ErrAppCallerNotAllowed = errors.New("app is pinned to another executable")
+)
+
+// ParseAppToken splits an app token into the app ID and its secret
+func ParseAppToken(token string) (appID, secret string, ok bool) {
+	rest, found := strings.CutPrefix(token, appTokenPrefix)
+	if !found {
+		return "", "", false
+	}
+	appID, secret, found = strings.Cut(rest, ".")
+	if !found || appID == "" || secret == "" {
+		return "", "", false
+	}
+	return appID, secret, true
+}
+
+func hashAppSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueAppSecret generates a new secret for the app and returns the token to
+// hand to the app. A caller known from the Unix socket gets its own secret,
+// so granting another executable does not break the tokens of earlier ones.
+// Otherwise the secret not tied to a caller is replaced. The caller saves the
+// app.
+func IssueAppSecret(app *store.AppInfo, caller *store.AppCaller) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+
+	if caller != nil {
+		if app.CallerSecrets == nil {
+			app.CallerSecrets = map[string]string{}
+		}
+		app.CallerSecrets[caller.Key()] = hashAppSecret(secret)
+	} else {
+		app.SecretHash = hashAppSecret(secret)
+	}
+	now := time.Now()
+	app.SecretIssued = &now
+	return appTokenPrefix + app.ID + "." + secret, nil
+}
+
+// HasAppSecret reports whether any secret was issued to the app
+func HasAppSecret(app store.AppInfo) bool {
+	return app.SecretHash != "" || len(app.CallerSecrets) > 0
+}
+
+// VerifyAppToken returns the app a token was issued to. Pinned apps only
+// accept tokens from the caller they were issued to, or for a secret not tied
+// to a caller, from the app's last caller. Callers over TCP are unknown and
+// cannot use pinned apps.
+func VerifyAppToken(token string, caller *store.AppCaller) (store.AppInfo, error) {
+	appID, secret, ok := ParseAppToken(token)
+	if !ok {
+		return store.AppInfo{}, ErrInvalidAppToken
+	}
+	app, err := store.Apps.Get(appID)
+	if err != nil || app.ID == "" {
+		return store.AppInfo{}, ErrInvalidAppToken
+	}
+
+	hash := []byte(hashAppSecret(secret))
+	issuedTo, found := "", false
+	if app.SecretHash != "" && subtle.ConstantTimeCompare(hash, []byte(app.SecretHash)) == 1 {
+		found = true
+	}
+	for key, stored := range app.CallerSecrets {
+		if subtle.ConstantTimeCompare(hash, []byte(stored)) == 1 {
+			issuedTo, found = key, true
+		}
+	}
+	if !found {
+		return store.AppInfo{}, ErrInvalidAppToken
+	}
+
+	if app.PinExecutable {
+		pinned := app.Caller
+		if issuedTo != "" {
+			pinned = nil
+			if caller != nil && caller.Key() == issuedTo {
+				pinned = caller
+			}
+		}
+		if caller == nil || pinned == nil || *caller != *pinned {
+			return store.AppInfo{}, ErrAppCallerNotAllowed
+		}
+	}
+	return app, nil
+}
+
+// RotateAppSecret issues a new secret for a stored app, invalidating all
+// previous ones
+func RotateAppSecret(appID string) (string, error) {
+	app, err := store.Apps.Get(appID)
+	if err != nil || app.ID == "" {
+		return "", errors.New("app not found")
+	}
+	app.CallerSecrets = nil
+	token, err := IssueAppSecret(&app, nil)
+	if err != nil {
+		return "", err
+	}
+	return token, store.Apps.Put(appID, app)
+}
+
+// RevokeAppSecret removes an app's secret. The app has to register again.
+func RevokeAppSecret(appID string) error {
+	app, err := store.Apps.Get(appID)
+	if err != nil || app.ID == "" {
+		return errors.New("app not found")
+	}
+	app.SecretHash = ""
+	app.CallerSecrets = nil
+	app.SecretIssued = nil
+	return store.Apps.Put(appID, app)
+}
@@ -0,0 +1,97 @@
+package logic
+
+import (
+	"testing"
+
+	"uni-token-service/store"
+)
+
+func TestIssueAppSecretPerCaller(t *testing.T) {
+	initTestStore(t)
+	app := store.AppInfo{ID: "app", Granted: true}
+	first := &store.AppCaller{Executable: "/usr/bin/first", UID: 1000}
+	second := &store.AppCaller{Executable: "/usr/bin/second", UID: 1000}
+
+	issue := func(caller *store.AppCaller) string {
+		t.Helper()
+		token, err := IssueAppSecret(&app, caller)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Apps.Put(app.ID, app); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := func(token string) bool {
+		_, err := VerifyAppToken(token, nil)
+		return err == nil
+	}
+
+	firstToken := issue(first)
+	secondToken := issue(second)
+	anonymous := issue(nil)
+	if !valid(firstToken) || !valid(secondToken) || !valid(anonymous) {
+		t.Fatal("granting another caller invalidated an earlier token")
+	}
+
+	reissued := issue(first)
+	if valid(firstToken) || !valid(reissued) || !valid(secondToken) {
+		t.Error("re-granting a caller should only replace its own token")
+	}
+
+	rotated, err := RotateAppSecret(app.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid(reissued) || valid(secondToken) || valid(anonymous) || !valid(rotated) {
+		t.Error("rotation should invalidate all previous tokens")
+	}
+
+	if err := RevokeAppSecret(app.ID); err != nil {
+		t.Fatal(err)
+	}
+	if valid(rotated) {
+		t.Error("revoked token still verifies")
+	}
+	if valid("uta_app.") || valid("app") {
+		t.Error("malformed tokens verify")
+	}
+}
+
+func TestVerifyAppTokenPinned(t *testing.T) {
+	initTestStore(t)
+	first := &store.AppCaller{Executable: "/usr/bin/first", UID: 1000}
+	second := &store.AppCaller{Executable: "/usr/bin/second", UID: 1000}
+	other := &store.AppCaller{Executable: "/usr/bin/first", UID: 1001}
+	app := store.AppInfo{ID: "app", Granted: true, PinExecutable: true, Caller: second}
+
+	firstToken, _ := IssueAppSecret(&app, first)
+	secondToken, _ := IssueAppSecret(&app, second)
+	rotated, _ := IssueAppSecret(&app, nil)
+	if err := store.Apps.Put(app.ID, app); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		caller *store.AppCaller
+		want   error
+	}{
+		{"issued caller", firstToken, first, nil},
+		{"other granted caller", firstToken, second, ErrAppCallerNotAllowed},
+		{"other user", firstToken, other, ErrAppCallerNotAllowed},
+		{"unknown caller", firstToken, nil, ErrAppCallerNotAllowed},
+		{"second caller", secondToken, second, nil},
+		{"secret without caller from the last caller", rotated, second, nil},
+		{"secret without caller from another caller", rotated, first, ErrAppCallerNotAllowed},
+		{"secret without caller over TCP", rotated, nil, ErrAppCallerNotAllowed},
+		{"wrong secret", "uta_app.wrong", first, ErrInvalidAppToken},
+	}
+	for _, tt := range tests {
+		if _, err := VerifyAppToken(tt.token, tt.caller); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -2,54 +2,161 @@ package logic
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
 	"time"
 
+	"uni-token-service/store"
+
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+const jwtLifetime = 24 * time.Hour
+
 func MakeToken() string {
 	return "TODO"
 }
 
-var jwtSecret = make([]byte, 32)
+var (
+	jwtKeys     = make(map[string][]byte)
+	jwtActiveID string
+	jwtKeysMu   sync.RWMutex
+)
 
+// InitJWTSecret loads the signing keys from the store, creating the first
+// one if needed, so sessions survive restarts.
 func InitJWTSecret() {
-	// Generate a random 32-byte secret for JWT signing
-	if _, err := rand.Read(jwtSecret); err != nil {
-		panic("failed to generate JWT secret: " + err.Error())
+	if err := loadJWTKeys(); err != nil {
+		panic("failed to load JWT secret: " + err.Error())
+	}
+	if err := store.PruneRevokedTokens(); err != nil {
+		panic("failed to prune revoked tokens: " + err.Error())
 	}
 }
 
+func loadJWTKeys() error {
+	keys, err := store.SigningKeys.List()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		_, err := RotateJWTSecret(false)
+		return err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	jwtKeysMu.Lock()
+	defer jwtKeysMu.Unlock()
+	for _, key := range keys {
+		secret, err := store.DecryptWithMasterKey(key.Secret)
+		if err != nil {
+			return err
+		}
+		jwtKeys[key.ID] = secret
+	}
+	jwtActiveID = keys[len(keys)-1].ID
+	return nil
+}
+
+// RotateJWTSecret starts signing with a new key. Sessions signed with older
+// keys stay valid until they expire, unless revokeSessions drops the old keys.
+// Keys no token can still be signed with are removed either way.
+func RotateJWTSecret(revokeSessions bool) (int, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return 0, err
+	}
+	encrypted, err := store.EncryptWithMasterKey(secret)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	newKey := store.SigningKey{
+		ID:        uuid.NewString(),
+		Secret:    encrypted,
+		CreatedAt: now,
+	}
+
+	keys, err := store.SigningKeys.List()
+	if err != nil {
+		return 0, err
+	}
+	if err := store.SigningKeys.Put(newKey.ID, newKey); err != nil {
+		return 0, err
+	}
+
+	jwtKeysMu.Lock()
+	defer jwtKeysMu.Unlock()
+	removed := 0
+	for _, key := range keys {
+		expired := key.RetiredAt != nil && now.Sub(*key.RetiredAt) > jwtLifetime
+		if revokeSessions || expired {
+			if err := store.SigningKeys.Delete(key.ID); err != nil {
+				return removed, err
+			}
+			delete(jwtKeys, key.ID)
+			removed++
+			continue
+		}
+		if key.RetiredAt == nil {
+			key.RetiredAt = &now
+			if err := store.SigningKeys.Put(key.ID, key); err != nil {
+				return removed, err
+			}
+		}
+	}
+	jwtKeys[newKey.ID] = secret
+	jwtActiveID = newKey.ID
+	return removed, nil
+}
+
+// JWTClaims are the claims of a UI session token. Id is the username and
+// RegisteredClaims.ID (jti) identifies the token for revocation.
 type JWTClaims struct {
 	Id string `json:"id"`
 	jwt.RegisteredClaims
 }
 
-func HashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
-}
-
 func GenerateJWT(id string) (string, error) {
 	claims := JWTClaims{
 		Id: id,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "uni-token-service",
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(jwtLifetime)),
 		},
 	}
 
+	jwtKeysMu.RLock()
+	keyID, secret := jwtActiveID, jwtKeys[jwtActiveID]
+	jwtKeysMu.RUnlock()
+	if secret == nil {
+		return "", errors.New("JWT secret is not initialized")
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token.Header["kid"] = keyID
+	return token.SignedString(secret)
 }
 
 func ValidateJWT(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+		keyID, _ := token.Header["kid"].(string)
+		jwtKeysMu.RLock()
+		defer jwtKeysMu.RUnlock()
+		secret, ok := jwtKeys[keyID]
+		if !ok {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil {
 		return nil, err
@@ -61,3 +168,36 @@ func ValidateJWT(tokenString string) (*JWTClaims, error) {
 
 	return nil, jwt.ErrSignatureInvalid
 }
+
+// IsJWTRevoked reports whether a session was logged out, or issued before
+// its user revoked all sessions
+func IsJWTRevoked(claims *JWTClaims) bool {
+	if claims.ID == "" || store.IsTokenRevoked(claims.ID) {
+		return true
+	}
+	user, err := store.Users.Get(claims.Id)
+	if err != nil || user.SessionsNotBefore == nil {
+		return false
+	}
+	return claims.IssuedAt == nil || claims.IssuedAt.Before(*user.SessionsNotBefore)
+}
+
+// RevokeUserSessions marks all sessions of the user issued so far as revoked.
+// Tokens carry whole seconds, so sessions issued right after stay valid. The
+// caller saves the user.
+func RevokeUserSessions(user *store.UserInfo) {
+	now := time.Now().Truncate(time.Second)
+	user.SessionsNotBefore = &now
+}
+
+// RevokeJWT puts a session token on the revocation list until it expires
+func RevokeJWT(claims *JWTClaims) error {
+	expiresAt := time.Now().Add(jwtLifetime)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return store.RevokedTokens.Put(claims.ID, store.RevokedToken{
+		ID:        claims.ID,
+		ExpiresAt: expiresAt,
+	})
+}
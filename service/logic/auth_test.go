@@ -0,0 +1,54 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"uni-token-service/store"
+)
+
+func TestRevokeUserSessions(t *testing.T) {
+	initTestStore(t)
+	InitJWTSecret()
+	if err := store.Users.Put("alice", store.UserInfo{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	session := func() *JWTClaims {
+		t.Helper()
+		token, err := GenerateJWT("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := ValidateJWT(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims
+	}
+
+	old := session()
+	old.IssuedAt.Time = old.IssuedAt.Add(-time.Second)
+	if IsJWTRevoked(old) {
+		t.Fatal("session revoked before any revocation")
+	}
+
+	user, _ := store.Users.Get("alice")
+	RevokeUserSessions(&user)
+	if err := store.Users.Put("alice", user); err != nil {
+		t.Fatal(err)
+	}
+	if !IsJWTRevoked(old) {
+		t.Error("session issued before the revocation is still valid")
+	}
+	if fresh := session(); IsJWTRevoked(fresh) {
+		t.Error("session issued right after the revocation is revoked")
+	}
+
+	if err := RevokeJWT(old); err != nil {
+		t.Fatal(err)
+	}
+	if !IsJWTRevoked(old) {
+		t.Error("logged out session is still valid")
+	}
+}
@@ -0,0 +1,49 @@
+package logic
+
+import (
+	"fmt"
+
+	"uni-token-service/store"
+)
+
+// BudgetExceededError is returned when an app or key has used up its budget
+type BudgetExceededError struct {
+	Scope string // "app" or "key"
+	Name  string
+	Limit string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("The %s budget of %s %q has been exceeded", budgetLimitNames[e.Limit], e.Scope, e.Name)
+}
+
+var budgetLimitNames = map[string]string{
+	"dailyTokens":   "daily token",
+	"monthlyTokens": "monthly token",
+	"dailyCost":     "daily cost",
+	"monthlyCost":   "monthly cost",
+}
+
+// CheckAppBudget returns a BudgetExceededError if the app is over budget
+func CheckAppBudget(app store.AppInfo) error {
+	state, err := store.GetAppBudgetState(app)
+	if err != nil {
+		return err
+	}
+	if state != nil && state.Exceeded != "" {
+		return &BudgetExceededError{Scope: "app", Name: app.Name, Limit: state.Exceeded}
+	}
+	return nil
+}
+
+// CheckKeyBudget returns a BudgetExceededError if the key is over budget
+func CheckKeyBudget(key store.LLMKey) error {
+	state, err := store.GetKeyBudgetState(key)
+	if err != nil {
+		return err
+	}
+	if state != nil && state.Exceeded != "" {
+		return &BudgetExceededError{Scope: "key", Name: key.Name, Limit: state.Exceeded}
+	}
+	return nil
+}
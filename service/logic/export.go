@@ -0,0 +1,219 @@
+package logic
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"uni-token-service/store"
+)
+
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatJSONL = "jsonl"
+)
+
+// DailyUsage aggregates the usage of one app, key and model over a day
+type DailyUsage struct {
+	Date         string  `json:"date"`
+	AppID        string  `json:"appId"`
+	AppName      string  `json:"appName"`
+	KeyID        string  `json:"keyId"`
+	Key          string  `json:"key"`
+	Model        string  `json:"model"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	PromptTokens int     `json:"promptTokens"`
+	OutputTokens int     `json:"outputTokens"`
+	TotalTokens  int     `json:"totalTokens"`
+	Cost         float64 `json:"cost"`
+}
+
+var usageCSVHeader = []string{
+	"id", "timestamp", "appId", "appName", "keyId", "key", "model", "endpoint", "status",
+	"promptTokens", "outputTokens", "totalTokens", "cost",
+}
+
+var dailyUsageCSVHeader = []string{
+	"date", "appId", "appName", "keyId", "key", "model", "requests", "errors",
+	"promptTokens", "outputTokens", "totalTokens", "cost",
+}
+
+// ValidateExportFormat returns an error for unknown export formats
+func ValidateExportFormat(format string) error {
+	if format != ExportFormatCSV && format != ExportFormatJSONL {
+		return fmt.Errorf("unsupported export format %q, expected csv or jsonl", format)
+	}
+	return nil
+}
+
+// ExportUsage writes matching usage records in chronological order as CSV or
+// JSON Lines. With byDay, records are aggregated per day, app, key and model.
+func ExportUsage(w io.Writer, filter store.UsageFilter, format string, byDay bool) error {
+	if err := ValidateExportFormat(format); err != nil {
+		return err
+	}
+	if byDay {
+		return exportDailyUsage(w, filter, format)
+	}
+
+	writer := newRowWriter(w, format, usageCSVHeader)
+	var writeErr error
+	err := store.ScanUsageForward(filter, func(usage store.TokenUsage) bool {
+		writeErr = writer.write(usage, []string{
+			usage.ID,
+			usage.Timestamp.Format(time.RFC3339),
+			usage.AppID,
+			usage.AppName,
+			usage.KeyID,
+			usage.Key,
+			usage.Model,
+			usage.Endpoint,
+			usage.Status,
+			strconv.Itoa(usage.PromptTokens),
+			strconv.Itoa(usage.OutputTokens),
+			strconv.Itoa(usage.TotalTokens),
+			formatCost(usage.Cost),
+		})
+		return writeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	return writer.flush()
+}
+
+func exportDailyUsage(w io.Writer, filter store.UsageFilter, format string) error {
+	type dailyKey struct {
+		date, appID, keyID, key, model string
+	}
+	days := make(map[dailyKey]*DailyUsage)
+
+	err := store.ScanUsageForward(filter, func(usage store.TokenUsage) bool {
+		date := usage.Timestamp.Local().Format(time.DateOnly)
+		k := dailyKey{date, usage.AppID, usage.KeyID, usage.Key, usage.Model}
+		day, ok := days[k]
+		if !ok {
+			day = &DailyUsage{
+				Date:    date,
+				AppID:   usage.AppID,
+				AppName: usage.AppName,
+				KeyID:   usage.KeyID,
+				Key:     usage.Key,
+				Model:   usage.Model,
+			}
+			days[k] = day
+		}
+		day.Requests++
+		if usage.Status == "error" {
+			day.Errors++
+		}
+		day.PromptTokens += usage.PromptTokens
+		day.OutputTokens += usage.OutputTokens
+		day.TotalTokens += usage.TotalTokens
+		day.Cost += usage.Cost
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	rows := make([]*DailyUsage, 0, len(days))
+	for _, day := range days {
+		rows = append(rows, day)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.AppName != b.AppName {
+			return a.AppName < b.AppName
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Model < b.Model
+	})
+
+	writer := newRowWriter(w, format, dailyUsageCSVHeader)
+	for _, day := range rows {
+		err := writer.write(day, []string{
+			day.Date,
+			day.AppID,
+			day.AppName,
+			day.KeyID,
+			day.Key,
+			day.Model,
+			strconv.Itoa(day.Requests),
+			strconv.Itoa(day.Errors),
+			strconv.Itoa(day.PromptTokens),
+			strconv.Itoa(day.OutputTokens),
+			strconv.Itoa(day.TotalTokens),
+			formatCost(day.Cost),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return writer.flush()
+}
+
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', -1, 64)
+}
+
+// rowWriter writes either CSV rows or JSON lines, so each export only
+// describes its columns once
+type rowWriter struct {
+	format string
+	csv    *csv.Writer
+	json   *json.Encoder
+	header []string
+}
+
+func newRowWriter(w io.Writer, format string, header []string) *rowWriter {
+	if format == ExportFormatCSV {
+		return &rowWriter{format: format, csv: csv.NewWriter(w), header: header}
+	}
+	return &rowWriter{format: format, json: json.NewEncoder(w)}
+}
+
+func (r *rowWriter) write(record interface{}, row []string) error {
+	if r.format != ExportFormatCSV {
+		return r.json.Encode(record)
+	}
+	if r.header != nil {
+		if err := r.csv.Write(r.header); err != nil {
+			return err
+		}
+		r.header = nil
+	}
+	if err := r.csv.Write(row); err != nil {
+		return err
+	}
+	// Flush every row so large exports stream instead of buffering
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+func (r *rowWriter) flush() error {
+	if r.format != ExportFormatCSV {
+		return nil
+	}
+	// Always emit the header, even for an empty export
+	if r.header != nil {
+		if err := r.csv.Write(r.header); err != nil {
+			return err
+		}
+	}
+	r.csv.Flush()
+	return r.csv.Error()
+}
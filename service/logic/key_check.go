@@ -0,0 +1,173 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"uni-token-service/store"
+)
+
+const keyCheckTimeout = 15 * time.Second
+
+// KeyCheckResult describes whether a key works and what it can access
+type KeyCheckResult struct {
+	OK         bool     `json:"ok"`
+	StatusCode int      `json:"statusCode,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	LatencyMs  int64    `json:"latencyMs"`
+	Models     []string `json:"models"`
+}
+
+type modelListResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+	HasMore bool   `json:"has_more"`
+	LastID  string `json:"last_id"`
+}
+
+// ModelsURL returns the model listing endpoint of a key's provider
+func ModelsURL(key store.LLMKey) (string, error) {
+	if KeyProtocol(key) == ProtocolAnthropic && !strings.HasSuffix(strings.TrimSuffix(key.BaseURL, "/"), "/v1") {
+		return url.JoinPath(key.BaseURL, "v1", "models")
+	}
+	return url.JoinPath(key.BaseURL, "models")
+}
+
+// CheckKey lists the models of a key's provider, which verifies both the base
+// URL and the token
+func CheckKey(key store.LLMKey) (result KeyCheckResult) {
+	result.Models = []string{}
+
+	token, err := store.OpenLLMKey(key)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	modelsURL, err := ModelsURL(key)
+	if err != nil {
+		result.Error = "invalid base URL: " + err.Error()
+		return result
+	}
+
+	client := &http.Client{Timeout: keyCheckTimeout}
+	started := time.Now()
+	defer func() { result.LatencyMs = time.Since(started).Milliseconds() }()
+
+	// Anthropic pages its model list, OpenAI-compatible providers return it at once
+	afterID := ""
+	for {
+		req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if afterID != "" {
+			req.URL.RawQuery = url.Values{"after_id": {afterID}}.Encode()
+		}
+		SetUpstreamAuth(req, KeyProtocol(key), token)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			result.Error = "request failed: " + err.Error()
+			return result
+		}
+		page, err := readModelList(resp)
+		resp.Body.Close()
+		result.StatusCode = resp.StatusCode
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		for _, model := range page.Data {
+			result.Models = append(result.Models, model.ID)
+		}
+		if !page.HasMore || page.LastID == "" || page.LastID == afterID {
+			break
+		}
+		afterID = page.LastID
+	}
+
+	slices.Sort(result.Models)
+	result.Models = slices.Compact(result.Models)
+	result.OK = true
+	return result
+}
+
+func readModelList(resp *http.Response) (modelListResponse, error) {
+	var page modelListResponse
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return page, fmt.Errorf("authentication failed (%d)", resp.StatusCode)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return page, fmt.Errorf("provider returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return page, fmt.Errorf("unexpected model list response: %w", err)
+	}
+	return page, nil
+}
+
+// TestKey checks a stored key, records when, and caches its model list on
+// success
+func TestKey(keyID string) (KeyCheckResult, error) {
+	key, err := store.LLMKeys.Get(keyID)
+	if err != nil {
+		return KeyCheckResult{}, err
+	}
+
+	result := CheckKey(key)
+	_, err = store.SaveKeyCheck(key, result.OK, result.Models, time.Now())
+	return result, err
+}
+
+// How long a key whose test failed waits before it is tested again in the
+// background
+const keyRecheckInterval = time.Hour
+
+var (
+	keysInCheck   = make(map[string]bool)
+	keysInCheckMu sync.Mutex
+)
+
+// NeedsKeyCheck reports whether a key has no model list and was not tested
+// recently
+func NeedsKeyCheck(key store.LLMKey) bool {
+	if key.ModelsUpdatedAt != nil {
+		return false
+	}
+	return key.ModelsCheckedAt == nil || time.Since(*key.ModelsCheckedAt) > keyRecheckInterval
+}
+
+// CheckKeyInBackground tests a key that needs it without blocking the caller.
+// A key is only tested once at a time.
+func CheckKeyInBackground(key store.LLMKey) {
+	if !NeedsKeyCheck(key) {
+		return
+	}
+	keysInCheckMu.Lock()
+	defer keysInCheckMu.Unlock()
+	if keysInCheck[key.ID] {
+		return
+	}
+	keysInCheck[key.ID] = true
+
+	go func() {
+		defer func() {
+			keysInCheckMu.Lock()
+			delete(keysInCheck, key.ID)
+			keysInCheckMu.Unlock()
+		}()
+		if _, err := TestKey(key.ID); err != nil {
+			log.Printf("Failed to test key %s: %v", key.Name, err)
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+package logic
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"uni-token-service/store"
+)
+
+const (
+	KeyStrategyFailover = "failover"
+	KeyStrategyWeighted = "weighted"
+)
+
+const (
+	defaultKeyCooldown = 10 * time.Second
+	maxKeyCooldown     = 10 * time.Minute
+)
+
+var (
+	keyCooldowns   = make(map[string]time.Time)
+	keyCooldownsMu sync.Mutex
+)
+
+// AppKeyRefs returns the keys an app may use, falling back to the single
+// legacy Key field for apps granted before key pools existed.
+func AppKeyRefs(app store.AppInfo) []store.AppKeyRef {
+	if len(app.Keys) > 0 {
+		return app.Keys
+	}
+	if app.Key != "" {
+		return []store.AppKeyRef{{ID: app.Key}}
+	}
+	return nil
+}
+
+// ResolveAppKeys returns the keys to try for a request, in order. Failover
+// apps keep their configured order while weighted apps are shuffled by weight.
+// Keys that recently failed are moved to the end rather than dropped, so a
+// request still has somewhere to go when every key is cooling down.
+func ResolveAppKeys(app store.AppInfo) []store.LLMKey {
+	refs := AppKeyRefs(app)
+	if app.KeyStrategy == KeyStrategyWeighted {
+		refs = weightedOrder(refs)
+	}
+
+	var ready, coolingDown []store.LLMKey
+	for _, ref := range refs {
+		key, err := store.LLMKeys.Get(ref.ID)
+		if err != nil {
+			continue
+		}
+		if IsKeyCoolingDown(key.ID) {
+			coolingDown = append(coolingDown, key)
+		} else {
+			ready = append(ready, key)
+		}
+	}
+	return append(ready, coolingDown...)
+}
+
+func weightedOrder(refs []store.AppKeyRef) []store.AppKeyRef {
+	remaining := append([]store.AppKeyRef(nil), refs...)
+	ordered := make([]store.AppKeyRef, 0, len(refs))
+	for len(remaining) > 0 {
+		total := 0
+		for _, ref := range remaining {
+			total += max(ref.Weight, 1)
+		}
+		pick := rand.IntN(total)
+		for i, ref := range remaining {
+			pick -= max(ref.Weight, 1)
+			if pick < 0 {
+				ordered = append(ordered, ref)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// IsRetryableStatus reports whether an upstream status should be retried
+// against the next key.
+func IsRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// MarkKeyUnavailable deprioritizes a key after a failure. A zero duration
+// applies the default cooldown.
+func MarkKeyUnavailable(keyID string, cooldown time.Duration) {
+	if cooldown <= 0 {
+		cooldown = defaultKeyCooldown
+	}
+	cooldown = min(cooldown, maxKeyCooldown)
+
+	keyCooldownsMu.Lock()
+	defer keyCooldownsMu.Unlock()
+	keyCooldowns[keyID] = time.Now().Add(cooldown)
+}
+
+// IsKeyCoolingDown reports whether a key failed recently
+func IsKeyCoolingDown(keyID string) bool {
+	keyCooldownsMu.Lock()
+	defer keyCooldownsMu.Unlock()
+	until, ok := keyCooldowns[keyID]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(keyCooldowns, keyID)
+		return false
+	}
+	return true
+}
+
+// ParseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date. It returns zero when the header is absent or invalid.
+func ParseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,139 @@
+package logic
+
+import (
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"uni-token-service/store"
+)
+
+// initTestStore opens a fresh database for the duration of a test
+func initTestStore(t *testing.T) {
+	t.Helper()
+	store.Init(filepath.Join(t.TempDir(), "data.db"))
+	t.Cleanup(func() { store.Close() })
+}
+
+func keyIDs(keys []store.LLMKey) []string {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+func TestResolveAppKeys(t *testing.T) {
+	initTestStore(t)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := store.LLMKeys.Put(id, store.LLMKey{ID: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		app     store.AppInfo
+		cooling []string
+		want    []string
+	}{
+		{
+			name: "no keys",
+			app:  store.AppInfo{},
+			want: []string{},
+		},
+		{
+			name: "legacy single key",
+			app:  store.AppInfo{Key: "b"},
+			want: []string{"b"},
+		},
+		{
+			name: "pool takes precedence over the single key",
+			app:  store.AppInfo{Key: "a", Keys: []store.AppKeyRef{{ID: "c"}, {ID: "b"}}},
+			want: []string{"c", "b"},
+		},
+		{
+			name: "missing keys are skipped",
+			app:  store.AppInfo{Keys: []store.AppKeyRef{{ID: "gone"}, {ID: "a"}}},
+			want: []string{"a"},
+		},
+		{
+			name:    "keys cooling down go last",
+			app:     store.AppInfo{Keys: []store.AppKeyRef{{ID: "a"}, {ID: "b"}, {ID: "c"}}},
+			cooling: []string{"a"},
+			want:    []string{"b", "c", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, id := range tt.cooling {
+				MarkKeyUnavailable(id, time.Minute)
+			}
+			t.Cleanup(func() {
+				keyCooldownsMu.Lock()
+				clear(keyCooldowns)
+				keyCooldownsMu.Unlock()
+			})
+
+			if got := keyIDs(ResolveAppKeys(tt.app)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAppKeysWeighted(t *testing.T) {
+	initTestStore(t)
+	for _, id := range []string{"heavy", "light"} {
+		if err := store.LLMKeys.Put(id, store.LLMKey{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := store.AppInfo{
+		KeyStrategy: KeyStrategyWeighted,
+		Keys:        []store.AppKeyRef{{ID: "light", Weight: 1}, {ID: "heavy", Weight: 9}},
+	}
+
+	first := map[string]int{}
+	for range 2000 {
+		keys := keyIDs(ResolveAppKeys(app))
+		if len(keys) != 2 {
+			t.Fatalf("expected both keys, got %v", keys)
+		}
+		first[keys[0]]++
+	}
+	// heavy should lead about 90% of the time
+	if first["heavy"] < 1600 || first["heavy"] > 1950 {
+		t.Errorf("heavy key led %d of 2000 orderings", first["heavy"])
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"30", 30 * time.Second},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.header); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+
+	// HTTP dates are relative to now, so allow for the clock moving on
+	header := time.Now().Add(2 * time.Minute).UTC().Format(http.TimeFormat)
+	if got := ParseRetryAfter(header); got < 110*time.Second || got > 2*time.Minute {
+		t.Errorf("ParseRetryAfter(%q) = %v, want about 2m", header, got)
+	}
+	header = time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if got := ParseRetryAfter(header); got > 0 {
+		t.Errorf("ParseRetryAfter(%q) = %v, want a date in the past to give no wait", header, got)
+	}
+}
@@ -0,0 +1,88 @@
+package logic
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	loginFailureWindow   = 15 * time.Minute
+	loginFreeAttempts    = 5
+	loginBaseLockout     = time.Minute
+	loginMaxLockout      = time.Hour
+	loginThrottleMaxKeys = 10000
+)
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var (
+	loginThrottle   = make(map[string]*loginAttempts)
+	loginThrottleMu sync.Mutex
+)
+
+// CheckLoginAllowed returns how long the username or client is still locked
+// out, or zero if it may try to log in
+func CheckLoginAllowed(username, client string) time.Duration {
+	loginThrottleMu.Lock()
+	defer loginThrottleMu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range loginThrottleKeys(username, client) {
+		if attempts, ok := loginThrottle[key]; ok && attempts.lockedUntil.After(now) {
+			wait = max(wait, attempts.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// RecordLoginFailure counts a failed attempt. After loginFreeAttempts
+// failures the username and client are locked out, twice as long for every
+// further failure.
+func RecordLoginFailure(username, client string) {
+	loginThrottleMu.Lock()
+	defer loginThrottleMu.Unlock()
+
+	now := time.Now()
+	if len(loginThrottle) >= loginThrottleMaxKeys {
+		pruneLoginThrottle(now)
+	}
+	for _, key := range loginThrottleKeys(username, client) {
+		attempts, ok := loginThrottle[key]
+		if !ok || now.Sub(attempts.lastFailure) > loginFailureWindow {
+			attempts = &loginAttempts{}
+			loginThrottle[key] = attempts
+		}
+		attempts.failures++
+		attempts.lastFailure = now
+		if attempts.failures >= loginFreeAttempts {
+			lockout := loginBaseLockout << min(attempts.failures-loginFreeAttempts, 6)
+			attempts.lockedUntil = now.Add(min(lockout, loginMaxLockout))
+		}
+	}
+}
+
+// RecordLoginSuccess forgets the failures of a username and client
+func RecordLoginSuccess(username, client string) {
+	loginThrottleMu.Lock()
+	defer loginThrottleMu.Unlock()
+	for _, key := range loginThrottleKeys(username, client) {
+		delete(loginThrottle, key)
+	}
+}
+
+func loginThrottleKeys(username, client string) []string {
+	return []string{"user:" + username, "client:" + client}
+}
+
+func pruneLoginThrottle(now time.Time) {
+	for key, attempts := range loginThrottle {
+		if now.Sub(attempts.lastFailure) > loginFailureWindow && !attempts.lockedUntil.After(now) {
+			delete(loginThrottle, key)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package logic
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// MetricsRegistry holds the gateway metrics served at /metrics
+var MetricsRegistry = prometheus.NewRegistry()
+
+var gatewayLabels = []string{"app", "key", "model"}
+
+var (
+	gatewayRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uni_token_gateway_requests_total",
+		Help: "Gateway requests sent upstream, by result.",
+	}, append(gatewayLabels, "status"))
+
+	gatewayUpstreamResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uni_token_gateway_upstream_responses_total",
+		Help: "Upstream responses by HTTP status code. Code 0 means the upstream could not be reached.",
+	}, append(gatewayLabels, "code"))
+
+	gatewayDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "uni_token_gateway_request_duration_seconds",
+		Help:    "Time from sending a request upstream until its response is fully relayed.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, gatewayLabels)
+
+	gatewayTimeToFirstToken = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "uni_token_gateway_time_to_first_token_seconds",
+		Help:    "Time from sending a streaming request upstream until its first chunk arrives.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, gatewayLabels)
+
+	gatewayTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uni_token_gateway_tokens_total",
+		Help: "Tokens used through the gateway, by type (prompt or output).",
+	}, append(gatewayLabels, "type"))
+
+	gatewayCost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uni_token_gateway_cost_usd_total",
+		Help: "Estimated cost of gateway requests in USD.",
+	}, gatewayLabels)
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		gatewayRequests,
+		gatewayUpstreamResponses,
+		gatewayDuration,
+		gatewayTimeToFirstToken,
+		gatewayTokens,
+		gatewayCost,
+	)
+}
+
+// GatewayObservation describes one upstream attempt of a gateway request
+type GatewayObservation struct {
+	App        string
+	Key        string
+	Model      string
+	StatusCode int // 0 when the upstream could not be reached
+	Duration   time.Duration
+	// TimeToFirstToken is only set for streaming responses
+	TimeToFirstToken time.Duration
+	PromptTokens     int
+	OutputTokens     int
+	Cost             float64
+}
+
+// ObserveGatewayRequest updates the gateway metrics. It is called wherever
+// usage is recorded, so metrics and usage records agree.
+func ObserveGatewayRequest(o GatewayObservation) {
+	labels := prometheus.Labels{"app": o.App, "key": o.Key, "model": o.Model}
+
+	status := "success"
+	if o.StatusCode == 0 || o.StatusCode >= 400 {
+		status = "error"
+	}
+	gatewayRequests.MustCurryWith(labels).WithLabelValues(status).Inc()
+	gatewayUpstreamResponses.MustCurryWith(labels).WithLabelValues(strconv.Itoa(o.StatusCode)).Inc()
+
+	if o.Duration > 0 {
+		gatewayDuration.With(labels).Observe(o.Duration.Seconds())
+	}
+	if o.TimeToFirstToken > 0 {
+		gatewayTimeToFirstToken.With(labels).Observe(o.TimeToFirstToken.Seconds())
+	}
+	if o.PromptTokens > 0 {
+		gatewayTokens.MustCurryWith(labels).WithLabelValues("prompt").Add(float64(o.PromptTokens))
+	}
+	if o.OutputTokens > 0 {
+		gatewayTokens.MustCurryWith(labels).WithLabelValues("output").Add(float64(o.OutputTokens))
+	}
+	if o.Cost > 0 {
+		gatewayCost.With(labels).Add(o.Cost)
+	}
+}
@@ -0,0 +1,136 @@
+package logic
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"uni-token-service/store"
+)
+
+var ErrModelNotAllowed = errors.New("model is not allowed for this app")
+
+// AppModel is one entry of the model list the gateway reports to an app
+type AppModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// IsModelsPath reports whether a gateway path lists models, with or without a
+// version prefix.
+func IsModelsPath(p string) bool {
+	return p == "/models" || p == "/models/" || p == "/v1/models" || p == "/v1/models/"
+}
+
+// IsModelAllowed checks a model against an app's allowlist. Entries may use
+// the same patterns as model prices, such as "gpt-4o*". An empty allowlist
+// allows every model.
+func IsModelAllowed(app store.AppInfo, model string) bool {
+	if len(app.AllowedModels) == 0 {
+		return true
+	}
+	for _, pattern := range app.AllowedModels {
+		if matchGlob(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveAppModel applies an app's aliases to a requested model and checks
+// the result against its allowlist.
+func ResolveAppModel(app store.AppInfo, model string) (string, error) {
+	if target, ok := app.ModelAliases[model]; ok {
+		model = target
+	}
+	if !IsModelAllowed(app, model) {
+		return model, ErrModelNotAllowed
+	}
+	return model, nil
+}
+
+// KeyServesModel reports whether a key can be routed a model. Keys that were
+// never tested have no model list and are assumed to serve everything.
+func KeyServesModel(key store.LLMKey, model string) bool {
+	return len(key.Models) == 0 || slices.Contains(key.Models, model)
+}
+
+// ListAppModels merges the cached model lists of every key an app may use,
+// filtered by its allowlist, plus the aliases that point at one of them. Keys
+// without a list are tested in the background for later listings. known is
+// false when no key has a model list, so the listing is best left to the
+// provider.
+func ListAppModels(app store.AppInfo) (models []AppModel, known bool) {
+	owners := map[string]string{}
+	for _, ref := range AppKeyRefs(app) {
+		key, err := store.LLMKeys.Get(ref.ID)
+		if err != nil {
+			continue
+		}
+		CheckKeyInBackground(key)
+		if len(key.Models) > 0 {
+			known = true
+		}
+		for _, model := range key.Models {
+			if _, seen := owners[model]; !seen && IsModelAllowed(app, model) {
+				owners[model] = key.Name
+			}
+		}
+	}
+	for alias, target := range app.ModelAliases {
+		if owner, ok := owners[target]; ok {
+			owners[alias] = owner
+		}
+	}
+
+	models = make([]AppModel, 0, len(owners))
+	for id, owner := range owners {
+		models = append(models, AppModel{
+			ID:      id,
+			Object:  "model",
+			Created: app.CreatedAt.Unix(),
+			OwnedBy: owner,
+		})
+	}
+	slices.SortFunc(models, func(a, b AppModel) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return models, known
+}
+
+// SetAppModels replaces an app's allowlist and aliases
+func SetAppModels(appID string, allowed []string, aliases map[string]string) error {
+	if slices.Contains(allowed, "") {
+		return errors.New("model patterns must not be empty")
+	}
+	for alias, target := range aliases {
+		if alias == "" || target == "" {
+			return errors.New("model aliases must not be empty")
+		}
+	}
+
+	app, err := store.Apps.Get(appID)
+	if err != nil {
+		return errors.New("app not found")
+	}
+	app.AllowedModels = allowed
+	app.ModelAliases = aliases
+	return store.Apps.Put(app.ID, app)
+}
+
+// ReplaceRequestModel rewrites the model field of a JSON request body
+func ReplaceRequestModel(body []byte, model string) ([]byte, error) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	req["model"] = value
+	return json.Marshal(req)
+}
@@ -0,0 +1,109 @@
+package logic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"uni-token-service/store"
+)
+
+func TestListAppModels(t *testing.T) {
+	initTestStore(t)
+	var requests, brokenRequests atomic.Int32
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"data":[{"id":"gpt-4o"},{"id":"gpt-4o-mini"},{"id":"o1"}]}`))
+	}))
+	defer provider.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		brokenRequests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer broken.Close()
+
+	keys := []store.LLMKey{
+		{ID: "untested", Name: "untested", Protocol: ProtocolOpenAI, BaseURL: provider.URL, Token: "t"},
+		{ID: "no-list", Name: "no-list", Protocol: ProtocolOpenAI, BaseURL: broken.URL, Token: "t"},
+	}
+	for _, key := range keys {
+		if err := store.LLMKeys.Put(key.ID, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForCheck := func(id string) {
+		t.Helper()
+		for range 100 {
+			if key, _ := store.LLMKeys.Get(id); key.ModelsCheckedAt != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("key %s was not tested in the background", id)
+	}
+
+	// No key can list its models, so the listing is left to the provider
+	noList := store.AppInfo{Key: "no-list"}
+	if _, known := ListAppModels(noList); known {
+		t.Error("a key without a model list made the listing known")
+	}
+	waitForCheck("no-list")
+	// The failed test is recorded, so the key is not tested again
+	ListAppModels(noList)
+	if n := brokenRequests.Load(); n != 1 {
+		t.Errorf("broken provider was asked %d times, want once", n)
+	}
+
+	app := store.AppInfo{
+		Key:           "untested",
+		AllowedModels: []string{"GPT-4O*"},
+		ModelAliases:  map[string]string{"fast": "gpt-4o-mini", "reasoning": "o1"},
+	}
+	// Untested keys are tested in the background, not during the listing
+	if _, known := ListAppModels(app); known {
+		t.Error("an untested key made the listing known")
+	}
+	waitForCheck("untested")
+	models, known := ListAppModels(app)
+	if !known {
+		t.Fatal("the tested key's model list was not used")
+	}
+	var ids []string
+	for _, model := range models {
+		ids = append(ids, model.ID)
+	}
+	if want := []string{"fast", "gpt-4o", "gpt-4o-mini"}; !slices.Equal(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+
+	// The list is cached, so the provider is not asked again
+	ListAppModels(app)
+	if n := requests.Load(); n != 1 {
+		t.Errorf("provider was asked %d times, want once", n)
+	}
+}
+
+func TestIsModelAllowed(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		model   string
+		want    bool
+	}{
+		{nil, "anything", true},
+		{[]string{"gpt-4o"}, "gpt-4o", true},
+		{[]string{"gpt-4o"}, "gpt-4o-mini", false},
+		{[]string{"claude-*"}, "Claude-3-Haiku", true},
+		{[]string{"openai/*"}, "openai/gpt-4o", true},
+		{[]string{"*/gpt-4o"}, "openrouter/openai/gpt-4o", true},
+		{[]string{"o1", "o3*"}, "o3-mini", true},
+		{[]string{"o1", "o3*"}, "gpt-4o", false},
+	}
+	for _, tt := range tests {
+		if got := IsModelAllowed(store.AppInfo{AllowedModels: tt.allowed}, tt.model); got != tt.want {
+			t.Errorf("IsModelAllowed(%v, %q) = %v, want %v", tt.allowed, tt.model, got, tt.want)
+		}
+	}
+}
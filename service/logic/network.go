@@ -0,0 +1,114 @@
+package logic
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"uni-token-service/store"
+)
+
+// LoopbackAddresses are always listened on, so local clients keep working
+var LoopbackAddresses = []string{"127.0.0.1", "::1"}
+
+// ListenAddresses returns the addresses the API server listens on. "::"
+// covers both loopback addresses, while "0.0.0.0" only covers IPv4, so ::1 is
+// added for clients resolving localhost to it.
+func ListenAddresses(settings store.Settings) []string {
+	if settings.BindAddress == "" {
+		return LoopbackAddresses
+	}
+	addr, err := netip.ParseAddr(settings.BindAddress)
+	if err == nil && addr.IsUnspecified() {
+		if addr.Is6() {
+			return []string{settings.BindAddress}
+		}
+		return []string{settings.BindAddress, "::1"}
+	}
+	if err == nil && addr.IsLoopback() {
+		return LoopbackAddresses
+	}
+	return append(append([]string{}, LoopbackAddresses...), settings.BindAddress)
+}
+
+// ListenNetwork returns the network to listen on an address with. Go would
+// listen on both IP versions for 0.0.0.0, so it is limited to IPv4 to match
+// ListenAddresses.
+func ListenNetwork(address string) string {
+	if addr, err := netip.ParseAddr(address); err == nil && addr.Is4() && addr.IsUnspecified() {
+		return "tcp4"
+	}
+	return "tcp"
+}
+
+// IsClientAllowed reports whether a remote address may use the API.
+// Loopback clients are always allowed, others must match an allowed CIDR.
+func IsClientAllowed(remoteAddr string, allowedCIDRs []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() {
+		return true
+	}
+
+	for _, cidr := range allowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveSettings validates and stores the service settings
+func SaveSettings(settings store.Settings) error {
+	if settings.BindAddress != "" {
+		if _, err := netip.ParseAddr(settings.BindAddress); err != nil {
+			return fmt.Errorf("invalid bind address %q", settings.BindAddress)
+		}
+	}
+	for _, cidr := range settings.AllowedCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+	}
+	return store.SaveSettings(settings)
+}
+
+// The service listens on the first free port of this range
+const (
+	PortRangeStart = 18760
+	PortRangeEnd   = PortRangeStart + 10
+)
+
+// IsPortAvailable reports whether a TCP port can be listened on
+func IsPortAvailable(address string, port int) bool {
+	listener, err := net.Listen(ListenNetwork(address), net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// UsableAddresses drops ::1 on machines without IPv6
+func UsableAddresses(addresses []string) []string {
+	usable := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address == "::1" && !IsPortAvailable(address, 0) {
+			continue
+		}
+		usable = append(usable, address)
+	}
+	return usable
+}
@@ -0,0 +1,40 @@
+package logic
+
+import (
+	"slices"
+	"testing"
+
+	"uni-token-service/store"
+)
+
+func TestListenAddresses(t *testing.T) {
+	tests := []struct {
+		bind string
+		want []string
+	}{
+		{"", []string{"127.0.0.1", "::1"}},
+		{"127.0.0.1", []string{"127.0.0.1", "::1"}},
+		{"0.0.0.0", []string{"0.0.0.0", "::1"}},
+		{"::", []string{"::"}},
+		{"192.168.1.10", []string{"127.0.0.1", "::1", "192.168.1.10"}},
+	}
+	for _, tt := range tests {
+		if got := ListenAddresses(store.Settings{BindAddress: tt.bind}); !slices.Equal(got, tt.want) {
+			t.Errorf("ListenAddresses(%q) = %v, want %v", tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestListenNetwork(t *testing.T) {
+	tests := map[string]string{
+		"0.0.0.0":   "tcp4",
+		"::":        "tcp",
+		"127.0.0.1": "tcp",
+		"::1":       "tcp",
+	}
+	for address, want := range tests {
+		if got := ListenNetwork(address); got != want {
+			t.Errorf("ListenNetwork(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
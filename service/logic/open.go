@@ -15,6 +15,9 @@ import (
 
 var ServerPort = -1
 
+// ServerSocket is the Unix socket the API is served on, empty if none
+var ServerSocket = ""
+
 var sessionActive = make(map[string]chan<- struct{})
 
 func OpenAction(actionType string, params url.Values) (<-chan struct{}, func(), error) {
@@ -32,7 +35,14 @@ func OpenUI(path string, params url.Values, auth bool) (<-chan struct{}, func(),
 		if len(allUsers) == 0 {
 			userName = constants.UserName
 		} else {
+			// Open the UI as the admin, falling back to the oldest account
 			userName = allUsers[0].Username
+			for _, user := range allUsers {
+				if user.Admin {
+					userName = user.Username
+					break
+				}
+			}
 		}
 
 		token, err := GenerateJWT(userName)
@@ -22,3 +22,8 @@ func OpenBrowser(targetUser string, url string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// FindBrowser returns the command used to open pages in the browser
+func FindBrowser() (string, error) {
+	return exec.LookPath("open")
+}
@@ -3,6 +3,7 @@
 package openBrowser
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"strings"
@@ -28,3 +29,14 @@ func OpenBrowser(targetUser string, url string) error {
 
 	return &exec.Error{Name: strings.Join(providers, ","), Err: exec.ErrNotFound}
 }
+
+// FindBrowser returns the command used to open pages in the browser
+func FindBrowser() (string, error) {
+	providers := []string{"xdg-open", "x-www-browser", "www-browser"}
+	for _, provider := range providers {
+		if path, err := exec.LookPath(provider); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s is installed", strings.Join(providers, ", "))
+}
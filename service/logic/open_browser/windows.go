@@ -87,3 +87,9 @@ func OpenBrowser(targetUser string, url string) error {
 		return windows.ShellExecute(0, nil, windows.StringToUTF16Ptr(url), nil, nil, windows.SW_SHOWNORMAL)
 	}
 }
+
+// FindBrowser returns how pages are opened in the browser. Windows always
+// has the shell handler.
+func FindBrowser() (string, error) {
+	return "ShellExecute", nil
+}
@@ -0,0 +1,98 @@
+package logic
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"uni-token-service/store"
+)
+
+// Argon2id parameters, following the RFC 9106 recommendation for memory
+// constrained environments
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// HashPassword hashes a password with Argon2id and a random salt. The result
+// is a PHC string that carries its own parameters.
+func HashPassword(password string) string {
+	salt := make([]byte, argon2SaltLen)
+	rand.Read(salt)
+	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash))
+}
+
+// VerifyPassword checks a password against a stored hash in constant time.
+// needsRehash is set for hashes from older schemes or weaker parameters,
+// which should be replaced after a successful login.
+func VerifyPassword(password, stored string) (ok bool, needsRehash bool) {
+	if !strings.HasPrefix(stored, "$argon2id$") {
+		// Unsalted SHA-256 from earlier versions
+		sum := sha256.Sum256([]byte(password))
+		legacy := hex.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(legacy), []byte(stored)) == 1, true
+	}
+
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(hash, expected) != 1 {
+		return false, false
+	}
+	needsRehash = memory < argon2Memory || time < argon2Time || len(salt) < argon2SaltLen
+	return true, needsRehash
+}
+
+var ErrInvalidPassword = errors.New("invalid username or password")
+
+// ChangePassword changes a user's password after checking the old one. Other
+// sessions of the user end, and provider keys wrapped with the old password
+// are re-wrapped with the new one.
+func ChangePassword(username, oldPassword, newPassword string) error {
+	user, err := store.Users.Get(username)
+	if err != nil {
+		return ErrInvalidPassword
+	}
+	if ok, _ := VerifyPassword(oldPassword, user.Password); !ok {
+		return ErrInvalidPassword
+	}
+
+	user.Password = HashPassword(newPassword)
+	RevokeUserSessions(&user)
+	return store.SaveUserPassword(user, oldPassword, newPassword)
+}
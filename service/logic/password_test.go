@@ -0,0 +1,64 @@
+package logic
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// weakHash builds an Argon2id hash with custom parameters, as older versions
+// or other tools may have written
+func weakHash(password string, memory, time uint32, saltLen int) string {
+	salt := make([]byte, saltLen)
+	hash := argon2.IDKey([]byte(password), salt, time, memory, 1, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=1$%s$%s", argon2.Version, memory, time,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+func TestVerifyPassword(t *testing.T) {
+	legacySum := sha256.Sum256([]byte("secret"))
+	legacy := hex.EncodeToString(legacySum[:])
+	current := HashPassword("secret")
+
+	tests := []struct {
+		name       string
+		password   string
+		stored     string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"legacy SHA-256 is accepted and upgraded", "secret", legacy, true, true},
+		{"legacy SHA-256 with a wrong password", "wrong", legacy, false, true},
+		{"current Argon2id", "secret", current, true, false},
+		{"current Argon2id with a wrong password", "wrong", current, false, false},
+		{"weaker memory cost is upgraded", "secret", weakHash("secret", 8*1024, argon2Time, argon2SaltLen), true, true},
+		{"fewer passes are upgraded", "secret", weakHash("secret", argon2Memory, 1, argon2SaltLen), true, true},
+		{"short salt is upgraded", "secret", weakHash("secret", argon2Memory, argon2Time, 8), true, true},
+		{"wrong version", "secret", "$argon2id$v=16$m=65536,t=3,p=4$AAAA$AAAA", false, false},
+		{"missing fields", "secret", "$argon2id$v=19$m=65536,t=3,p=4$AAAA", false, false},
+		{"bad parameters", "secret", "$argon2id$v=19$m=x$AAAA$AAAA", false, false},
+		{"bad salt", "secret", "$argon2id$v=19$m=65536,t=3,p=4$!!!$AAAA", false, false},
+		{"empty hash", "secret", "$argon2id$v=19$m=65536,t=3,p=4$AAAA$", false, false},
+		{"empty stored value", "", "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := VerifyPassword(tt.password, tt.stored)
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("VerifyPassword() = (%v, %v), want (%v, %v)", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestHashPasswordIsSalted(t *testing.T) {
+	a, b := HashPassword("secret"), HashPassword("secret")
+	if a == b {
+		t.Error("hashes of the same password should differ")
+	}
+}
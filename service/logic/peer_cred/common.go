@@ -0,0 +1,36 @@
+package peerCred
+
+import (
+	"context"
+	"net"
+)
+
+// Credentials identify the process on the other end of a Unix socket
+type Credentials struct {
+	PID        int
+	UID        int
+	Executable string
+}
+
+type contextKey struct{}
+
+// ConnContext is an http.Server ConnContext hook that attaches the peer
+// credentials of Unix socket connections to their requests' context
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	creds, err := GetPeerCredentials(unixConn)
+	if err != nil || creds == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, creds)
+}
+
+// FromContext returns the peer credentials of the request's connection, or
+// nil when they are unknown, e.g. on TCP
+func FromContext(ctx context.Context) *Credentials {
+	creds, _ := ctx.Value(contextKey{}).(*Credentials)
+	return creds
+}
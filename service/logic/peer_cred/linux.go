@@ -0,0 +1,43 @@
+//go:build linux
+
+package peerCred
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetPeerCredentials reads the caller's PID and UID with SO_PEERCRED and
+// resolves its executable from /proc
+func GetPeerCredentials(conn *net.UnixConn) (*Credentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	executable, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", ucred.Pid))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{
+		PID:        int(ucred.Pid),
+		UID:        int(ucred.Uid),
+		Executable: executable,
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package peerCred
+
+import "net"
+
+// GetPeerCredentials is only supported on Linux
+func GetPeerCredentials(conn *net.UnixConn) (*Credentials, error) {
+	return nil, nil
+}
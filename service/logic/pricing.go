@@ -0,0 +1,203 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"uni-token-service/store"
+
+	"github.com/google/uuid"
+)
+
+// ModelPricing represents the pricing structure for a model
+type ModelPricing struct {
+	PromptRate       float64 // USD per 1K tokens
+	OutputRate       float64 // USD per 1K tokens
+	CachedPromptRate float64 // USD per 1K tokens
+	ReasoningRate    float64 // USD per 1K tokens
+	ImageRate        float64 // USD per image
+}
+
+// GetModelPricing returns pricing information for a model served by a key.
+// The most specific catalogue entry wins, and the built-in defaults are used
+// when nothing matches.
+func GetModelPricing(key store.LLMKey, model string) ModelPricing {
+	prices, err := store.Pricing.List()
+	if err == nil {
+		if price, ok := matchModelPrice(prices, key, model); ok {
+			pricing := ModelPricing{
+				PromptRate:       price.PromptRate,
+				OutputRate:       price.OutputRate,
+				CachedPromptRate: price.CachedPromptRate,
+				ReasoningRate:    price.ReasoningRate,
+				ImageRate:        price.ImageRate,
+			}
+			if pricing.CachedPromptRate == 0 {
+				pricing.CachedPromptRate = pricing.PromptRate
+			}
+			if pricing.ReasoningRate == 0 {
+				pricing.ReasoningRate = pricing.OutputRate
+			}
+			return pricing
+		}
+	}
+	return defaultModelPricing(model)
+}
+
+func matchModelPrice(prices []store.ModelPrice, key store.LLMKey, model string) (store.ModelPrice, bool) {
+	var best store.ModelPrice
+	bestScore := -1
+	for _, price := range prices {
+		if !matchGlob(price.Model, model) {
+			continue
+		}
+		score := literalLength(price.Model)
+		if price.Provider != "" {
+			if !matchGlob(price.Provider, key.Type) && !matchGlob(price.Provider, key.BaseURL) {
+				continue
+			}
+			// Provider-specific prices always beat generic ones
+			score += 1 << 16
+		}
+		if score > bestScore {
+			best, bestScore = price, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// matchGlob matches a case-insensitive pattern where * matches any run of
+// characters, including slashes, and ? matches exactly one character.
+func matchGlob(pattern, name string) bool {
+	pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	p, n := 0, 0
+	starP, starN := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starN = p, n
+			p++
+		case starP >= 0:
+			starN++
+			p, n = starP+1, starN
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func literalLength(pattern string) int {
+	return len(pattern) - strings.Count(pattern, "*") - strings.Count(pattern, "?")
+}
+
+// defaultModelPricing returns the built-in pricing for a model
+func defaultModelPricing(model string) ModelPricing {
+	modelLower := strings.ToLower(model)
+
+	var pricing ModelPricing
+	switch {
+	case strings.Contains(modelLower, "gpt-4o"):
+		pricing = ModelPricing{
+			PromptRate: 0.005, // $0.005 per 1K prompt tokens
+			OutputRate: 0.015, // $0.015 per 1K output tokens
+		}
+	case strings.Contains(modelLower, "gpt-4"):
+		pricing = ModelPricing{
+			PromptRate: 0.03, // $0.03 per 1K prompt tokens
+			OutputRate: 0.06, // $0.06 per 1K output tokens
+		}
+	case strings.Contains(modelLower, "gpt-3.5"):
+		pricing = ModelPricing{
+			PromptRate: 0.0015, // $0.0015 per 1K prompt tokens
+			OutputRate: 0.002,  // $0.002 per 1K output tokens
+		}
+	case strings.Contains(modelLower, "claude"):
+		pricing = ModelPricing{
+			PromptRate: 0.008, // $0.008 per 1K prompt tokens
+			OutputRate: 0.024, // $0.024 per 1K output tokens
+		}
+	default:
+		pricing = ModelPricing{
+			PromptRate: 0.001, // Default rate
+			OutputRate: 0.002,
+		}
+	}
+	pricing.CachedPromptRate = pricing.PromptRate
+	pricing.ReasoningRate = pricing.OutputRate
+	return pricing
+}
+
+// CalculateCost calculates the cost of a request served by a key
+func CalculateCost(key store.LLMKey, model string, usage UsageData) float64 {
+	pricing := GetModelPricing(key, model)
+
+	cachedTokens := min(usage.CachedTokens, usage.PromptTokens)
+	reasoningTokens := min(usage.ReasoningTokens, usage.OutputTokens)
+
+	promptCost := float64(usage.PromptTokens-cachedTokens) / 1000.0 * pricing.PromptRate
+	cachedCost := float64(cachedTokens) / 1000.0 * pricing.CachedPromptRate
+	outputCost := float64(usage.OutputTokens-reasoningTokens) / 1000.0 * pricing.OutputRate
+	reasoningCost := float64(reasoningTokens) / 1000.0 * pricing.ReasoningRate
+	imageCost := float64(usage.Images) * pricing.ImageRate
+
+	return promptCost + cachedCost + outputCost + reasoningCost + imageCost
+}
+
+func validateModelPrice(price store.ModelPrice) error {
+	if price.Model == "" {
+		return fmt.Errorf("model pattern is required")
+	}
+	if price.PromptRate < 0 || price.OutputRate < 0 || price.CachedPromptRate < 0 ||
+		price.ReasoningRate < 0 || price.ImageRate < 0 {
+		return fmt.Errorf("rates must not be negative")
+	}
+	return nil
+}
+
+// SaveModelPrice validates and stores a pricing entry, assigning an ID to new entries
+func SaveModelPrice(price store.ModelPrice) (store.ModelPrice, error) {
+	if err := validateModelPrice(price); err != nil {
+		return price, err
+	}
+	if price.ID == "" {
+		price.ID = uuid.NewString()
+	}
+	return price, store.Pricing.Put(price.ID, price)
+}
+
+// ImportPricing stores every entry of a JSON array of prices. With replace,
+// the existing catalogue is cleared first.
+func ImportPricing(data []byte, replace bool) (int, error) {
+	var prices []store.ModelPrice
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return 0, fmt.Errorf("invalid pricing file: %w", err)
+	}
+
+	// Validate everything up front so a bad file leaves the catalogue untouched
+	for i, price := range prices {
+		if err := validateModelPrice(price); err != nil {
+			return 0, fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+
+	if replace {
+		if err := store.Pricing.Clear(); err != nil {
+			return 0, err
+		}
+	}
+
+	for i, price := range prices {
+		if _, err := SaveModelPrice(price); err != nil {
+			return i, fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+	return len(prices), nil
+}
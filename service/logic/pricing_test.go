@@ -0,0 +1,63 @@
+package logic
+
+import (
+	"testing"
+
+	"uni-token-service/store"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"gpt-4o", "gpt-4o", true},
+		{"gpt-4o", "gpt-4o-mini", false},
+		{"gpt-4o*", "gpt-4o-mini", true},
+		{"GPT-4O*", "gpt-4o-2024-08-06", true},
+		{"gpt-4o*", "GPT-4o", true},
+		{"*", "", true},
+		{"", "", true},
+		{"", "x", false},
+		{"openai*", "openai/gpt-4o", true},
+		{"*/gpt-4o", "openrouter/openai/gpt-4o", true},
+		{"claude-?-haiku", "claude-3-haiku", true},
+		{"claude-?-haiku", "claude-35-haiku", false},
+		{"*sonnet*", "claude-3-5-sonnet-latest", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"*.deepseek.com*", "https://api.deepseek.com/v1", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchModelPrice(t *testing.T) {
+	prices := []store.ModelPrice{
+		{ID: "generic", Model: "gpt-4o*"},
+		{ID: "exact", Model: "gpt-4o-mini"},
+		{ID: "provider", Model: "gpt-4o*", Provider: "*openrouter*"},
+	}
+	tests := []struct {
+		key   store.LLMKey
+		model string
+		want  string
+	}{
+		{store.LLMKey{Type: "manual"}, "gpt-4o", "generic"},
+		{store.LLMKey{Type: "manual"}, "gpt-4o-mini", "exact"},
+		{store.LLMKey{BaseURL: "https://openrouter.ai/api/v1"}, "gpt-4o-mini", "provider"},
+		{store.LLMKey{Type: "manual"}, "claude-3", ""},
+	}
+	for _, tt := range tests {
+		price, ok := matchModelPrice(prices, tt.key, tt.model)
+		if !ok {
+			price.ID = ""
+		}
+		if price.ID != tt.want {
+			t.Errorf("matchModelPrice(%+v, %q) = %q, want %q", tt.key, tt.model, price.ID, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package logic
+
+import (
+	"net/http"
+
+	"uni-token-service/store"
+)
+
+const (
+	ProtocolOpenAI    = "openai"
+	ProtocolAnthropic = "anthropic"
+)
+
+const defaultAnthropicVersion = "2023-06-01"
+
+// KeyProtocol returns the wire protocol spoken by a key's provider.
+// Keys created before the protocol field existed are OpenAI-compatible.
+func KeyProtocol(key store.LLMKey) string {
+	if key.Protocol == "" {
+		return ProtocolOpenAI
+	}
+	return key.Protocol
+}
+
+// SetUpstreamAuth sets the provider credentials in the form the protocol expects.
+func SetUpstreamAuth(req *http.Request, protocol string, token string) {
+	switch protocol {
+	case ProtocolAnthropic:
+		req.Header.Set("x-api-key", token)
+		if req.Header.Get("anthropic-version") == "" {
+			req.Header.Set("anthropic-version", defaultAnthropicVersion)
+		}
+	default:
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
@@ -0,0 +1,254 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"uni-token-service/store"
+)
+
+const (
+	rateLimitWindow       = time.Minute
+	rateLimitPollInterval = 250 * time.Millisecond
+)
+
+// RateLimitedError is returned when a request exceeds a rate limit
+type RateLimitedError struct {
+	Scope      string // "app" or "key"
+	Name       string
+	Reason     string // "requests", "tokens" or "concurrency"
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("Rate limit of %s %q exceeded (%s)", e.Scope, e.Name, e.Reason)
+}
+
+// RateLimitState is a snapshot of one limiter
+type RateLimitState struct {
+	Scope              string           `json:"scope"`
+	ID                 string           `json:"id"`
+	InFlight           int              `json:"inFlight"`
+	RequestsLastMinute int              `json:"requestsLastMinute"`
+	TokensLastMinute   int              `json:"tokensLastMinute"`
+	Limit              *store.RateLimit `json:"limit,omitempty"`
+}
+
+type tokenEvent struct {
+	at     time.Time
+	tokens int
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	requests []time.Time
+	tokens   []tokenEvent
+	inFlight int
+	limit    *store.RateLimit
+	removed  bool // dropped from rateLimiters while idle, get a new one
+}
+
+type rateLimiterID struct {
+	scope string
+	id    string
+}
+
+var (
+	rateLimiters      = make(map[rateLimiterID]*rateLimiter)
+	rateLimitersMu    sync.Mutex
+	rateLimitersSwept time.Time
+)
+
+// limiterRemoved is the reason tryAcquire gives for a limiter that was swept
+const limiterRemoved = "removed"
+
+func getRateLimiter(scope, id string) *rateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	limiterID := rateLimiterID{scope: scope, id: id}
+	limiter, ok := rateLimiters[limiterID]
+	if !ok {
+		if time.Since(rateLimitersSwept) > rateLimitWindow {
+			sweepRateLimiters()
+		}
+		limiter = &rateLimiter{}
+		rateLimiters[limiterID] = limiter
+	}
+	return limiter
+}
+
+// sweepRateLimiters drops limiters with nothing in flight and nothing left in
+// their window, such as those of deleted apps and keys. The caller holds
+// rateLimitersMu.
+func sweepRateLimiters() {
+	now := time.Now()
+	rateLimitersSwept = now
+	for id, limiter := range rateLimiters {
+		limiter.mu.Lock()
+		limiter.prune(now)
+		if limiter.inFlight == 0 && len(limiter.requests) == 0 && len(limiter.tokens) == 0 {
+			limiter.removed = true
+			delete(rateLimiters, id)
+		}
+		limiter.mu.Unlock()
+	}
+}
+
+// AcquireAppRateLimit reserves capacity for one request of an app
+func AcquireAppRateLimit(ctx context.Context, app store.AppInfo) (func(tokens int), error) {
+	return acquireRateLimit(ctx, "app", app.ID, app.Name, app.RateLimit)
+}
+
+// AcquireKeyRateLimit reserves capacity for one request on a key. Without
+// wait, the request is rejected immediately even if the key allows queueing.
+func AcquireKeyRateLimit(ctx context.Context, key store.LLMKey, wait bool) (func(tokens int), error) {
+	limit := key.RateLimit
+	if !wait && limit != nil {
+		noQueue := *limit
+		noQueue.QueueTimeoutSeconds = 0
+		limit = &noQueue
+	}
+	return acquireRateLimit(ctx, "key", key.ID, key.Name, limit)
+}
+
+// acquireRateLimit reserves capacity, waiting up to the queue timeout when the
+// limit allows it. The returned release function must be called with the
+// number of tokens the request used once it is done.
+func acquireRateLimit(ctx context.Context, scope, id, name string, limit *store.RateLimit) (func(tokens int), error) {
+	var deadline time.Time
+	if limit != nil && limit.QueueTimeoutSeconds > 0 {
+		deadline = time.Now().Add(time.Duration(limit.QueueTimeoutSeconds) * time.Second)
+	}
+
+	for {
+		limiter := getRateLimiter(scope, id)
+		reason, retryAfter := limiter.tryAcquire(limit)
+		if reason == limiterRemoved {
+			continue
+		}
+		if reason == "" {
+			var once sync.Once
+			return func(tokens int) {
+				once.Do(func() { limiter.release(tokens) })
+			}, nil
+		}
+
+		if deadline.IsZero() || time.Now().Add(min(retryAfter, rateLimitPollInterval)).After(deadline) {
+			return nil, &RateLimitedError{Scope: scope, Name: name, Reason: reason, RetryAfter: retryAfter}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(retryAfter, rateLimitPollInterval)):
+		}
+	}
+}
+
+// tryAcquire takes a slot if every limit allows it. Otherwise it returns the
+// limit that was hit and an estimate of when capacity frees up.
+func (l *rateLimiter) tryAcquire(limit *store.RateLimit) (string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.removed {
+		return limiterRemoved, 0
+	}
+
+	now := time.Now()
+	l.prune(now)
+	l.limit = limit
+
+	if limit != nil {
+		if limit.MaxConcurrent > 0 && l.inFlight >= limit.MaxConcurrent {
+			return "concurrency", time.Second
+		}
+		if limit.RequestsPerMinute > 0 && len(l.requests) >= limit.RequestsPerMinute {
+			return "requests", l.requests[0].Add(rateLimitWindow).Sub(now)
+		}
+		if used := l.tokensInWindow(); limit.TokensPerMinute > 0 && used >= limit.TokensPerMinute {
+			// Wait until enough old requests leave the window
+			for _, event := range l.tokens {
+				used -= event.tokens
+				if used < limit.TokensPerMinute {
+					return "tokens", event.at.Add(rateLimitWindow).Sub(now)
+				}
+			}
+		}
+	}
+
+	l.requests = append(l.requests, now)
+	l.inFlight++
+	return "", 0
+}
+
+func (l *rateLimiter) release(tokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if tokens > 0 {
+		l.tokens = append(l.tokens, tokenEvent{at: time.Now(), tokens: tokens})
+	}
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	cutoff := now.Add(-rateLimitWindow)
+	i := 0
+	for i < len(l.requests) && l.requests[i].Before(cutoff) {
+		i++
+	}
+	l.requests = l.requests[i:]
+
+	j := 0
+	for j < len(l.tokens) && l.tokens[j].at.Before(cutoff) {
+		j++
+	}
+	l.tokens = l.tokens[j:]
+}
+
+func (l *rateLimiter) tokensInWindow() int {
+	total := 0
+	for _, event := range l.tokens {
+		total += event.tokens
+	}
+	return total
+}
+
+// GetRateLimitStates returns a snapshot of every limiter with requests in
+// flight or in its window
+func GetRateLimitStates() []RateLimitState {
+	rateLimitersMu.Lock()
+	sweepRateLimiters()
+	ids := make([]rateLimiterID, 0, len(rateLimiters))
+	limiters := make(map[rateLimiterID]*rateLimiter, len(rateLimiters))
+	for id, limiter := range rateLimiters {
+		ids = append(ids, id)
+		limiters[id] = limiter
+	}
+	rateLimitersMu.Unlock()
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].scope != ids[j].scope {
+			return ids[i].scope < ids[j].scope
+		}
+		return ids[i].id < ids[j].id
+	})
+
+	states := make([]RateLimitState, 0, len(ids))
+	for _, id := range ids {
+		limiter := limiters[id]
+		limiter.mu.Lock()
+		limiter.prune(time.Now())
+		states = append(states, RateLimitState{
+			Scope:              id.scope,
+			ID:                 id.id,
+			InFlight:           limiter.inFlight,
+			RequestsLastMinute: len(limiter.requests),
+			TokensLastMinute:   limiter.tokensInWindow(),
+			Limit:              limiter.limit,
+		})
+		limiter.mu.Unlock()
+	}
+	return states
+}
@@ -0,0 +1,178 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"uni-token-service/store"
+)
+
+func TestRateLimiterTryAcquire(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		limiter    *rateLimiter
+		limit      *store.RateLimit
+		wantReason string
+	}{
+		{
+			name:    "no limit",
+			limiter: &rateLimiter{inFlight: 100},
+		},
+		{
+			name:       "concurrency",
+			limiter:    &rateLimiter{inFlight: 2},
+			limit:      &store.RateLimit{MaxConcurrent: 2},
+			wantReason: "concurrency",
+		},
+		{
+			name:       "requests per minute",
+			limiter:    &rateLimiter{requests: []time.Time{now.Add(-30 * time.Second), now}},
+			limit:      &store.RateLimit{RequestsPerMinute: 2},
+			wantReason: "requests",
+		},
+		{
+			name:    "requests leave the window",
+			limiter: &rateLimiter{requests: []time.Time{now.Add(-2 * time.Minute), now}},
+			limit:   &store.RateLimit{RequestsPerMinute: 2},
+		},
+		{
+			name:       "tokens per minute",
+			limiter:    &rateLimiter{tokens: []tokenEvent{{at: now.Add(-10 * time.Second), tokens: 600}, {at: now, tokens: 500}}},
+			limit:      &store.RateLimit{TokensPerMinute: 1000},
+			wantReason: "tokens",
+		},
+		{
+			name:    "tokens under the limit",
+			limiter: &rateLimiter{tokens: []tokenEvent{{at: now, tokens: 999}}},
+			limit:   &store.RateLimit{TokensPerMinute: 1000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inFlight := tt.limiter.inFlight
+			reason, retryAfter := tt.limiter.tryAcquire(tt.limit)
+			if reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", reason, tt.wantReason)
+			}
+			if reason == "" {
+				if tt.limiter.inFlight != inFlight+1 {
+					t.Errorf("inFlight = %d, want %d", tt.limiter.inFlight, inFlight+1)
+				}
+				return
+			}
+			if retryAfter <= 0 || retryAfter > rateLimitWindow {
+				t.Errorf("retryAfter = %v, want within the window", retryAfter)
+			}
+			if tt.limiter.inFlight != inFlight {
+				t.Errorf("a rejected request changed inFlight to %d", tt.limiter.inFlight)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	now := time.Now()
+	limiter := rateLimiter{
+		tokens: []tokenEvent{
+			{at: now.Add(-50 * time.Second), tokens: 300},
+			{at: now.Add(-20 * time.Second), tokens: 800},
+		},
+	}
+	// Dropping the oldest event is not enough, so the wait runs until the second leaves
+	_, retryAfter := limiter.tryAcquire(&store.RateLimit{TokensPerMinute: 800})
+	if retryAfter < 39*time.Second || retryAfter > 40*time.Second {
+		t.Errorf("retryAfter = %v, want about 40s", retryAfter)
+	}
+}
+
+func TestAcquireRateLimit(t *testing.T) {
+	limit := &store.RateLimit{MaxConcurrent: 1}
+	release, err := acquireRateLimit(context.Background(), "test", t.Name(), "n", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = acquireRateLimit(context.Background(), "test", t.Name(), "n", limit)
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) || limited.Reason != "concurrency" {
+		t.Fatalf("expected a concurrency error, got %v", err)
+	}
+
+	// Releasing twice must not free a second slot
+	release(10)
+	release(10)
+	states := map[string]RateLimitState{}
+	for _, state := range GetRateLimitStates() {
+		states[state.Scope+"/"+state.ID] = state
+	}
+	state := states["test/"+t.Name()]
+	if state.InFlight != 0 || state.TokensLastMinute != 10 || state.RequestsLastMinute != 1 {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
+
+func TestAcquireRateLimitQueues(t *testing.T) {
+	limit := &store.RateLimit{MaxConcurrent: 1, QueueTimeoutSeconds: 5}
+	release, err := acquireRateLimit(context.Background(), "test", t.Name(), "n", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release(0)
+	}()
+
+	started := time.Now()
+	if _, err := acquireRateLimit(context.Background(), "test", t.Name(), "n", limit); err != nil {
+		t.Fatalf("queued request failed: %v", err)
+	}
+	if waited := time.Since(started); waited < 50*time.Millisecond {
+		t.Errorf("queued request did not wait, took %v", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := acquireRateLimit(ctx, "test", t.Name(), "n", limit); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled context to end the wait, got %v", err)
+	}
+}
+
+func TestRateLimitersAreSwept(t *testing.T) {
+	limit := &store.RateLimit{MaxConcurrent: 1}
+	release, err := acquireRateLimit(context.Background(), "test", t.Name(), "n", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasState := func() bool {
+		for _, state := range GetRateLimitStates() {
+			if state.Scope == "test" && state.ID == t.Name() {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasState() {
+		t.Fatal("a limiter with a request in flight was swept")
+	}
+
+	// Let the request leave the window
+	release(0)
+	limiter := getRateLimiter("test", t.Name())
+	limiter.mu.Lock()
+	limiter.requests[0] = time.Now().Add(-2 * rateLimitWindow)
+	limiter.mu.Unlock()
+	if hasState() {
+		t.Error("an idle limiter was kept")
+	}
+	if reason, _ := limiter.tryAcquire(limit); reason != limiterRemoved {
+		t.Errorf("a swept limiter handed out a slot, reason %q", reason)
+	}
+
+	if _, err := acquireRateLimit(context.Background(), "test", t.Name(), "n", limit); err != nil {
+		t.Errorf("acquiring after a sweep failed: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package logic
+
+import (
+	"os"
+
+	"uni-token-service/constants"
+	"uni-token-service/store"
+)
+
+// ServiceStatus summarizes a service instance and its database
+type ServiceStatus struct {
+	Version      int           `json:"version"`
+	PID          int           `json:"pid"`
+	Port         int           `json:"port,omitempty"`
+	DbPath       string        `json:"dbPath"`
+	DbSize       int64         `json:"dbSize"`
+	Users        int           `json:"users"`
+	Apps         int           `json:"apps"`
+	GrantedApps  int           `json:"grantedApps"`
+	Keys         int           `json:"keys"`
+	KeyEndpoints []KeyEndpoint `json:"keyEndpoints"`
+}
+
+// KeyEndpoint is where a key sends requests, without its token
+type KeyEndpoint struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	BaseURL string `json:"baseUrl"`
+}
+
+// CollectStatus reads the status of the open store
+func CollectStatus() (ServiceStatus, error) {
+	status := newServiceStatus(store.Db.Path())
+	if ServerPort > 0 {
+		status.Port = ServerPort
+	}
+	inventory, err := store.ReadInventory(store.Db)
+	if err != nil {
+		return status, err
+	}
+	status.addInventory(inventory)
+	return status, nil
+}
+
+// CollectOfflineStatus reads the status of a database no service has open.
+// The file is opened read-only, so inspecting it changes nothing.
+func CollectOfflineStatus(dbPath string) (ServiceStatus, error) {
+	status := newServiceStatus(dbPath)
+	db, err := store.OpenReadOnly(dbPath)
+	if err != nil {
+		return status, err
+	}
+	defer db.Close()
+
+	inventory, err := store.ReadInventory(db)
+	if err != nil {
+		return status, err
+	}
+	status.addInventory(inventory)
+	return status, nil
+}
+
+func newServiceStatus(dbPath string) ServiceStatus {
+	status := ServiceStatus{
+		Version:      constants.Version,
+		PID:          os.Getpid(),
+		DbPath:       dbPath,
+		KeyEndpoints: []KeyEndpoint{},
+	}
+	if info, err := os.Stat(dbPath); err == nil {
+		status.DbSize = info.Size()
+	}
+	return status
+}
+
+func (status *ServiceStatus) addInventory(inventory store.Inventory) {
+	status.Users = inventory.Users
+	status.Apps = len(inventory.Apps)
+	for _, app := range inventory.Apps {
+		if app.Granted {
+			status.GrantedApps++
+		}
+	}
+	status.Keys = len(inventory.Keys)
+	for _, key := range inventory.Keys {
+		status.KeyEndpoints = append(status.KeyEndpoints, KeyEndpoint{
+			ID:      key.ID,
+			Name:    key.Name,
+			BaseURL: key.BaseURL,
+		})
+	}
+}
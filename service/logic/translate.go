@@ -0,0 +1,622 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Anthropic requires max_tokens, OpenAI does not
+const defaultAnthropicMaxTokens = 4096
+
+// IsChatCompletionsPath reports whether an OpenAI gateway path targets the
+// chat completions endpoint, with or without a version prefix.
+func IsChatCompletionsPath(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), "/chat/completions")
+}
+
+// AnthropicMessagesURL builds the Messages endpoint URL for an Anthropic key.
+// Base URLs may be configured with or without the trailing version segment.
+func AnthropicMessagesURL(baseURL string) (string, error) {
+	if strings.HasSuffix(strings.TrimSuffix(baseURL, "/"), "/v1") {
+		return url.JoinPath(baseURL, "messages")
+	}
+	return url.JoinPath(baseURL, "v1", "messages")
+}
+
+type openAIChatRequest struct {
+	Model               string              `json:"model"`
+	Messages            []openAIChatMessage `json:"messages"`
+	MaxTokens           int                 `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int                 `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64            `json:"temperature,omitempty"`
+	TopP                *float64            `json:"top_p,omitempty"`
+	Stop                json.RawMessage     `json:"stop,omitempty"`
+	Stream              bool                `json:"stream,omitempty"`
+	StreamOptions       *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	Tools             []openAITool    `json:"tools,omitempty"`
+	ToolChoice        json.RawMessage `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	User              string          `json:"user,omitempty"`
+}
+
+type openAIChatMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type anthropicRequest struct {
+	Model         string                 `json:"model"`
+	System        string                 `json:"system,omitempty"`
+	Messages      []anthropicMessage     `json:"messages"`
+	MaxTokens     int                    `json:"max_tokens"`
+	Temperature   *float64               `json:"temperature,omitempty"`
+	TopP          *float64               `json:"top_p,omitempty"`
+	StopSequences []string               `json:"stop_sequences,omitempty"`
+	Stream        bool                   `json:"stream,omitempty"`
+	Tools         []anthropicTool        `json:"tools,omitempty"`
+	ToolChoice    map[string]interface{} `json:"tool_choice,omitempty"`
+	Metadata      map[string]string      `json:"metadata,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Model      string                  `json:"model"`
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      map[string]interface{}  `json:"usage"`
+}
+
+type anthropicError struct {
+	Type  string `json:"type"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// WantsStreamUsage reports whether an OpenAI chat completions request asks
+// for usage in the final stream chunk.
+func WantsStreamUsage(body []byte) bool {
+	var req openAIChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+	return req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+}
+
+// OpenAIToAnthropicRequest converts an OpenAI chat completions request body
+// into an Anthropic Messages request body.
+func OpenAIToAnthropicRequest(body []byte) ([]byte, error) {
+	var req openAIChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid chat completions request: %w", err)
+	}
+
+	out := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxCompletionTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = req.MaxTokens
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = defaultAnthropicMaxTokens
+	}
+	if req.User != "" {
+		out.Metadata = map[string]string{"user_id": req.User}
+	}
+
+	stop, err := parseStringOrArray(req.Stop)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stop: %w", err)
+	}
+	out.StopSequences = stop
+
+	var system []string
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			text, err := contentText(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			system = append(system, text)
+		case "user":
+			blocks, err := contentBlocks(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = appendAnthropicMessage(out.Messages, "user", blocks)
+		case "assistant":
+			blocks, err := contentBlocks(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: input,
+				})
+			}
+			out.Messages = appendAnthropicMessage(out.Messages, "assistant", blocks)
+		case "tool":
+			text, err := contentText(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = appendAnthropicMessage(out.Messages, "user", []anthropicContentBlock{{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   text,
+			}})
+		default:
+			return nil, fmt.Errorf("unsupported message role: %s", msg.Role)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		if tool.Type != "" && tool.Type != "function" {
+			continue
+		}
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	toolChoice, err := convertToolChoice(req.ToolChoice)
+	if err != nil {
+		return nil, err
+	}
+	if req.ParallelToolCalls != nil && !*req.ParallelToolCalls && len(out.Tools) > 0 {
+		if toolChoice == nil {
+			toolChoice = map[string]interface{}{"type": "auto"}
+		}
+		toolChoice["disable_parallel_tool_use"] = true
+	}
+	if len(out.Tools) > 0 {
+		out.ToolChoice = toolChoice
+	}
+
+	return json.Marshal(out)
+}
+
+// appendAnthropicMessage merges consecutive turns of the same role, since
+// tool results arrive as separate OpenAI messages but belong to one user turn.
+func appendAnthropicMessage(messages []anthropicMessage, role string, blocks []anthropicContentBlock) []anthropicMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1].Content = append(messages[n-1].Content, blocks...)
+		return messages
+	}
+	return append(messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+func parseStringOrArray(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var list []string
+	err := json.Unmarshal(raw, &list)
+	return list, err
+}
+
+func parseContentParts(raw json.RawMessage) ([]openAIContentPart, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []openAIContentPart{{Type: "text", Text: text}}, nil
+	}
+	var parts []openAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("invalid message content: %w", err)
+	}
+	return parts, nil
+}
+
+func contentText(raw json.RawMessage) (string, error) {
+	parts, err := parseContentParts(raw)
+	if err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func contentBlocks(raw json.RawMessage) ([]anthropicContentBlock, error) {
+	parts, err := parseContentParts(raw)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]anthropicContentBlock, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			if part.Text != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: part.Text})
+			}
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			blocks = append(blocks, anthropicContentBlock{
+				Type:   "image",
+				Source: convertImageURL(part.ImageURL.URL),
+			})
+		}
+	}
+	return blocks, nil
+}
+
+// convertImageURL maps an OpenAI image URL, which may be a data URL, to an
+// Anthropic image source.
+func convertImageURL(imageURL string) *anthropicImageSource {
+	if rest, ok := strings.CutPrefix(imageURL, "data:"); ok {
+		mediaType, data, found := strings.Cut(rest, ";base64,")
+		if found {
+			return &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}
+		}
+	}
+	return &anthropicImageSource{Type: "url", URL: imageURL}
+}
+
+func convertToolChoice(raw json.RawMessage) (map[string]interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		switch mode {
+		case "auto":
+			return map[string]interface{}{"type": "auto"}, nil
+		case "required":
+			return map[string]interface{}{"type": "any"}, nil
+		case "none":
+			return map[string]interface{}{"type": "none"}, nil
+		}
+		return nil, fmt.Errorf("unsupported tool_choice: %s", mode)
+	}
+	var choice struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &choice); err != nil {
+		return nil, fmt.Errorf("invalid tool_choice: %w", err)
+	}
+	return map[string]interface{}{"type": "tool", "name": choice.Function.Name}, nil
+}
+
+func convertFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	case "":
+		return ""
+	default:
+		return "stop"
+	}
+}
+
+func openAIUsage(usage map[string]interface{}) map[string]int {
+	data := parseUsage(usage)
+	return map[string]int{
+		"prompt_tokens":     data.PromptTokens,
+		"completion_tokens": data.OutputTokens,
+		"total_tokens":      data.PromptTokens + data.OutputTokens,
+	}
+}
+
+// AnthropicToOpenAIResponse converts a non-streaming Anthropic Messages
+// response, or an Anthropic error, into the OpenAI chat completions format.
+func AnthropicToOpenAIResponse(body []byte) ([]byte, error) {
+	var apiErr anthropicError
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Type == "error" && apiErr.Error != nil {
+		return json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": apiErr.Error.Message,
+				"type":    apiErr.Error.Type,
+				"code":    apiErr.Error.Type,
+			},
+		})
+	}
+
+	var resp anthropicResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	var toolCalls []map[string]interface{}
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block.ID,
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block.Name,
+					"arguments": string(block.Input),
+				},
+			})
+		}
+	}
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": text.String(),
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":      resp.ID,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   resp.Model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       message,
+			"finish_reason": convertFinishReason(resp.StopReason),
+		}},
+		"usage": openAIUsage(resp.Usage),
+	})
+}
+
+// AnthropicStreamTranslator converts an Anthropic Messages event stream into
+// OpenAI chat completion chunks.
+type AnthropicStreamTranslator struct {
+	includeUsage bool
+	id           string
+	model        string
+	created      int64
+	usage        map[string]interface{}
+	// Maps Anthropic content block indices to OpenAI tool call indices
+	toolIndices map[int]int
+	buffer      string
+}
+
+// NewAnthropicStreamTranslator creates a new stream translator
+func NewAnthropicStreamTranslator(includeUsage bool) *AnthropicStreamTranslator {
+	return &AnthropicStreamTranslator{
+		includeUsage: includeUsage,
+		created:      time.Now().Unix(),
+		usage:        map[string]interface{}{},
+		toolIndices:  make(map[int]int),
+	}
+}
+
+// ProcessChunk consumes raw upstream bytes and returns the translated SSE bytes
+// that are ready to be sent to the client.
+func (t *AnthropicStreamTranslator) ProcessChunk(chunk []byte) []byte {
+	t.buffer += string(chunk)
+
+	var out strings.Builder
+	lines := strings.Split(t.buffer, "\n")
+	for _, line := range lines[:len(lines)-1] {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			continue
+		}
+		t.translateEvent(event, &out)
+	}
+	t.buffer = lines[len(lines)-1]
+
+	return []byte(out.String())
+}
+
+func (t *AnthropicStreamTranslator) translateEvent(event map[string]interface{}, out *strings.Builder) {
+	switch event["type"] {
+	case "message_start":
+		if message, ok := event["message"].(map[string]interface{}); ok {
+			t.id, _ = message["id"].(string)
+			t.model, _ = message["model"].(string)
+			if usage, ok := message["usage"].(map[string]interface{}); ok {
+				t.usage = usage
+			}
+		}
+		t.writeChunk(out, map[string]interface{}{"role": "assistant", "content": ""}, nil)
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]interface{})
+		if block["type"] != "tool_use" {
+			return
+		}
+		index := t.blockIndex(event)
+		toolIndex := len(t.toolIndices)
+		t.toolIndices[index] = toolIndex
+		t.writeChunk(out, map[string]interface{}{
+			"tool_calls": []map[string]interface{}{{
+				"index": toolIndex,
+				"id":    block["id"],
+				"type":  "function",
+				"function": map[string]interface{}{
+					"name":      block["name"],
+					"arguments": "",
+				},
+			}},
+		}, nil)
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]interface{})
+		switch delta["type"] {
+		case "text_delta":
+			t.writeChunk(out, map[string]interface{}{"content": delta["text"]}, nil)
+		case "input_json_delta":
+			toolIndex, ok := t.toolIndices[t.blockIndex(event)]
+			if !ok {
+				return
+			}
+			t.writeChunk(out, map[string]interface{}{
+				"tool_calls": []map[string]interface{}{{
+					"index":    toolIndex,
+					"function": map[string]interface{}{"arguments": delta["partial_json"]},
+				}},
+			}, nil)
+		}
+	case "message_delta":
+		if usage, ok := event["usage"].(map[string]interface{}); ok {
+			for k, v := range usage {
+				t.usage[k] = v
+			}
+		}
+		delta, _ := event["delta"].(map[string]interface{})
+		stopReason, _ := delta["stop_reason"].(string)
+		finishReason := convertFinishReason(stopReason)
+		t.writeChunk(out, map[string]interface{}{}, &finishReason)
+	case "message_stop":
+		if t.includeUsage {
+			t.writeData(out, map[string]interface{}{
+				"id":      t.id,
+				"object":  "chat.completion.chunk",
+				"created": t.created,
+				"model":   t.model,
+				"choices": []interface{}{},
+				"usage":   openAIUsage(t.usage),
+			})
+		}
+		out.WriteString("data: [DONE]\n\n")
+	case "error":
+		errInfo, _ := event["error"].(map[string]interface{})
+		t.writeData(out, map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": errInfo["message"],
+				"type":    errInfo["type"],
+				"code":    errInfo["type"],
+			},
+		})
+	}
+}
+
+func (t *AnthropicStreamTranslator) blockIndex(event map[string]interface{}) int {
+	index, _ := event["index"].(float64)
+	return int(index)
+}
+
+func (t *AnthropicStreamTranslator) writeChunk(out *strings.Builder, delta map[string]interface{}, finishReason *string) {
+	choice := map[string]interface{}{
+		"index":         0,
+		"delta":         delta,
+		"finish_reason": nil,
+	}
+	if finishReason != nil && *finishReason != "" {
+		choice["finish_reason"] = *finishReason
+	}
+	t.writeData(out, map[string]interface{}{
+		"id":      t.id,
+		"object":  "chat.completion.chunk",
+		"created": t.created,
+		"model":   t.model,
+		"choices": []map[string]interface{}{choice},
+	})
+}
+
+func (t *AnthropicStreamTranslator) writeData(out *strings.Builder, data map[string]interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	out.WriteString("data: ")
+	out.Write(encoded)
+	out.WriteString("\n\n")
+}
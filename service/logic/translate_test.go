@@ -0,0 +1,255 @@
+package logic
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return out
+}
+
+func TestOpenAIToAnthropicRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "defaults max_tokens and joins system prompts",
+			in: `{"model":"claude","messages":[
+				{"role":"system","content":"a"},
+				{"role":"developer","content":[{"type":"text","text":"b"}]},
+				{"role":"user","content":"hi"}]}`,
+			want: `{"model":"claude","system":"a\n\nb","max_tokens":4096,
+				"messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`,
+		},
+		{
+			name: "prefers max_completion_tokens and maps sampling options",
+			in: `{"model":"m","max_tokens":10,"max_completion_tokens":20,"temperature":0.5,
+				"stop":"END","stream":true,"user":"u1","messages":[{"role":"user","content":"x"}]}`,
+			want: `{"model":"m","max_tokens":20,"temperature":0.5,"stop_sequences":["END"],"stream":true,
+				"metadata":{"user_id":"u1"},"messages":[{"role":"user","content":[{"type":"text","text":"x"}]}]}`,
+		},
+		{
+			name: "converts images",
+			in: `{"model":"m","max_tokens":1,"messages":[{"role":"user","content":[
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}},
+				{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`,
+			want: `{"model":"m","max_tokens":1,"messages":[{"role":"user","content":[
+				{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}},
+				{"type":"image","source":{"type":"url","url":"https://example.com/a.png"}}]}]}`,
+		},
+		{
+			name: "converts tool calls and merges tool results into one user turn",
+			in: `{"model":"m","max_tokens":1,"messages":[
+				{"role":"user","content":"weather?"},
+				{"role":"assistant","content":null,"tool_calls":[
+					{"id":"c1","type":"function","function":{"name":"get","arguments":"{\"city\":\"a\"}"}},
+					{"id":"c2","type":"function","function":{"name":"get","arguments":"not json"}}]},
+				{"role":"tool","tool_call_id":"c1","content":"sunny"},
+				{"role":"tool","tool_call_id":"c2","content":"rain"}],
+				"tools":[{"type":"function","function":{"name":"get","description":"d"}}],
+				"tool_choice":"required","parallel_tool_calls":false}`,
+			want: `{"model":"m","max_tokens":1,"messages":[
+				{"role":"user","content":[{"type":"text","text":"weather?"}]},
+				{"role":"assistant","content":[
+					{"type":"tool_use","id":"c1","name":"get","input":{"city":"a"}},
+					{"type":"tool_use","id":"c2","name":"get","input":{}}]},
+				{"role":"user","content":[
+					{"type":"tool_result","tool_use_id":"c1","content":"sunny"},
+					{"type":"tool_result","tool_use_id":"c2","content":"rain"}]}],
+				"tools":[{"name":"get","description":"d","input_schema":{"type":"object","properties":{}}}],
+				"tool_choice":{"type":"any","disable_parallel_tool_use":true}}`,
+		},
+		{
+			name: "drops tool_choice without tools",
+			in:   `{"model":"m","max_tokens":1,"tool_choice":{"type":"function","function":{"name":"f"}},"messages":[]}`,
+			want: `{"model":"m","max_tokens":1,"messages":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OpenAIToAnthropicRequest([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if g, w := decodeJSON(t, got), decodeJSON(t, []byte(tt.want)); !reflect.DeepEqual(g, w) {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIToAnthropicRequestErrors(t *testing.T) {
+	tests := []string{
+		`not json`,
+		`{"messages":[{"role":"function","content":"x"}]}`,
+		`{"stop":1,"messages":[]}`,
+		`{"tool_choice":"sometimes","tools":[{"type":"function","function":{"name":"f"}}],"messages":[]}`,
+		`{"messages":[{"role":"user","content":42}]}`,
+	}
+	for _, in := range tests {
+		if _, err := OpenAIToAnthropicRequest([]byte(in)); err == nil {
+			t.Errorf("expected an error for %s", in)
+		}
+	}
+}
+
+func TestWantsStreamUsage(t *testing.T) {
+	tests := map[string]bool{
+		`{"stream":true,"stream_options":{"include_usage":true}}`:  true,
+		`{"stream":true,"stream_options":{"include_usage":false}}`: false,
+		`{"stream":true}`: false,
+		`invalid`:         false,
+	}
+	for in, want := range tests {
+		if got := WantsStreamUsage([]byte(in)); got != want {
+			t.Errorf("WantsStreamUsage(%s) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestAnthropicToOpenAIResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "text",
+			in: `{"id":"msg_1","model":"claude","content":[{"type":"text","text":"Hel"},{"type":"text","text":"lo"}],
+				"stop_reason":"end_turn","usage":{"input_tokens":3,"cache_read_input_tokens":2,"output_tokens":4}}`,
+			want: `{"id":"msg_1","object":"chat.completion","model":"claude","choices":[{"index":0,
+				"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],
+				"usage":{"prompt_tokens":5,"completion_tokens":4,"total_tokens":9}}`,
+		},
+		{
+			name: "tool use",
+			in: `{"id":"msg_2","model":"claude","content":[{"type":"tool_use","id":"t1","name":"get","input":{"a":1}}],
+				"stop_reason":"tool_use","usage":{"input_tokens":1,"output_tokens":1}}`,
+			want: `{"id":"msg_2","object":"chat.completion","model":"claude","choices":[{"index":0,
+				"message":{"role":"assistant","content":"","tool_calls":[{"id":"t1","type":"function",
+				"function":{"name":"get","arguments":"{\"a\":1}"}}]},"finish_reason":"tool_calls"}],
+				"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`,
+		},
+		{
+			name: "error",
+			in:   `{"type":"error","error":{"type":"overloaded_error","message":"busy"}}`,
+			want: `{"error":{"message":"busy","type":"overloaded_error","code":"overloaded_error"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AnthropicToOpenAIResponse([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			g := decodeJSON(t, got)
+			delete(g, "created")
+			if w := decodeJSON(t, []byte(tt.want)); !reflect.DeepEqual(g, w) {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// streamEvents splits translated SSE output into decoded data payloads
+func streamEvents(t *testing.T, out string) []interface{} {
+	t.Helper()
+	var events []interface{}
+	for _, block := range strings.Split(strings.TrimSpace(out), "\n\n") {
+		data, ok := strings.CutPrefix(block, "data: ")
+		if !ok {
+			t.Fatalf("unexpected SSE block %q", block)
+		}
+		if data == "[DONE]" {
+			events = append(events, data)
+			continue
+		}
+		event := decodeJSON(t, []byte(data))
+		delete(event, "created")
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestAnthropicStreamTranslator(t *testing.T) {
+	upstream := strings.Join([]string{
+		`event: message_start`,
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude","usage":{"input_tokens":5,"output_tokens":1}}}`,
+		``,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		``,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+		``,
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"t1","name":"get"}}`,
+		``,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"a\":"}}`,
+		``,
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+		``,
+		`data: {"type":"message_stop"}`,
+		``,
+		``,
+	}, "\n")
+
+	chunk := func(delta string, finish interface{}) map[string]interface{} {
+		var d map[string]interface{}
+		json.Unmarshal([]byte(delta), &d)
+		return map[string]interface{}{
+			"id": "msg_1", "object": "chat.completion.chunk", "model": "claude",
+			"choices": []interface{}{map[string]interface{}{"index": float64(0), "delta": d, "finish_reason": finish}},
+		}
+	}
+	want := []interface{}{
+		chunk(`{"role":"assistant","content":""}`, nil),
+		chunk(`{"content":"Hi"}`, nil),
+		chunk(`{"tool_calls":[{"index":0,"id":"t1","type":"function","function":{"name":"get","arguments":""}}]}`, nil),
+		chunk(`{"tool_calls":[{"index":0,"function":{"arguments":"{\"a\":"}}]}`, nil),
+		chunk(`{}`, "tool_calls"),
+		map[string]interface{}{
+			"id": "msg_1", "object": "chat.completion.chunk", "model": "claude", "choices": []interface{}{},
+			"usage": map[string]interface{}{"prompt_tokens": float64(5), "completion_tokens": float64(7), "total_tokens": float64(12)},
+		},
+		"[DONE]",
+	}
+
+	for _, size := range []int{len(upstream), 7, 1} {
+		translator := NewAnthropicStreamTranslator(true)
+		var out strings.Builder
+		// Upstream reads split events at arbitrary points
+		for i := 0; i < len(upstream); i += size {
+			out.Write(translator.ProcessChunk([]byte(upstream[i:min(i+size, len(upstream))])))
+		}
+		if got := streamEvents(t, out.String()); !reflect.DeepEqual(got, want) {
+			t.Errorf("chunk size %d: got %v\nwant %v", size, got, want)
+		}
+	}
+}
+
+func TestAnthropicStreamTranslatorWithoutUsage(t *testing.T) {
+	translator := NewAnthropicStreamTranslator(false)
+	out := string(translator.ProcessChunk([]byte("data: {\"type\":\"message_stop\"}\n\n")))
+	if out != "data: [DONE]\n\n" {
+		t.Errorf("got %q", out)
+	}
+
+	out = string(translator.ProcessChunk([]byte(`data: {"type":"error","error":{"type":"overloaded_error","message":"busy"}}` + "\n")))
+	events := streamEvents(t, out)
+	want := []interface{}{map[string]interface{}{
+		"error": map[string]interface{}{"message": "busy", "type": "overloaded_error", "code": "overloaded_error"},
+	}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, want %v", events, want)
+	}
+}
@@ -102,3 +102,22 @@ exec "%s" url "$@"
 
 	return nil
 }
+
+// CheckURLScheme verifies that the handler app bundle exists and runs the
+// expected executable
+func CheckURLScheme(options UrlSchemeRegisterOption) error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	appBundlePath := filepath.Join(homeDir, "Applications", options.AppName+".app")
+	wrapper, err := os.ReadFile(filepath.Join(appBundlePath, "Contents", "MacOS", options.AppName))
+	if err != nil {
+		return fmt.Errorf("app bundle %s is missing", appBundlePath)
+	}
+	if !strings.Contains(string(wrapper), fmt.Sprintf(`exec "%s" url`, options.ExecutablePath)) {
+		return fmt.Errorf("app bundle %s points to another executable", appBundlePath)
+	}
+	return nil
+}
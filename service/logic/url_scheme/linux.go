@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 func RegisterURLScheme(options UrlSchemeRegisterOption) error {
@@ -55,3 +56,30 @@ MimeType=x-scheme-handler/%s;
 
 	return nil
 }
+
+// CheckURLScheme verifies that the desktop entry exists, runs the expected
+// executable and is the default handler of the scheme
+func CheckURLScheme(options UrlSchemeRegisterOption) error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	desktopFileName := fmt.Sprintf("%s.desktop", options.AppName)
+	content, err := os.ReadFile(filepath.Join(homeDir, ".local", "share", "applications", desktopFileName))
+	if err != nil {
+		return fmt.Errorf("desktop entry %s is missing", desktopFileName)
+	}
+	if !strings.Contains(string(content), "Exec="+options.ExecutablePath+" ") {
+		return fmt.Errorf("desktop entry %s points to another executable", desktopFileName)
+	}
+
+	output, err := exec.Command("xdg-mime", "query", "default", fmt.Sprintf("x-scheme-handler/%s", options.Scheme)).Output()
+	if err != nil {
+		return fmt.Errorf("xdg-mime query failed: %w", err)
+	}
+	if handler := strings.TrimSpace(string(output)); handler != desktopFileName {
+		return fmt.Errorf("%s:// is handled by %q instead of %s", options.Scheme, handler, desktopFileName)
+	}
+	return nil
+}
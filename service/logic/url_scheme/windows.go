@@ -51,3 +51,23 @@ func RegisterURLScheme(options UrlSchemeRegisterOption) error {
 
 	return nil
 }
+
+// CheckURLScheme verifies that the registry command runs the expected
+// executable
+func CheckURLScheme(options UrlSchemeRegisterOption) error {
+	commandKey := "Software\\Classes\\" + options.Scheme + "\\shell\\open\\command"
+	k, err := registry.OpenKey(registry.CURRENT_USER, commandKey, registry.QUERY_VALUE)
+	if err != nil {
+		return fmt.Errorf("registry key %s is missing", commandKey)
+	}
+	defer k.Close()
+
+	command, _, err := k.GetStringValue("")
+	if err != nil {
+		return fmt.Errorf("failed to read registry key %s: %w", commandKey, err)
+	}
+	if !strings.Contains(command, filepath.ToSlash(options.ExecutablePath)) {
+		return fmt.Errorf("%s:// runs %s instead of this service", options.Scheme, command)
+	}
+	return nil
+}
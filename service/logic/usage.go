@@ -6,55 +6,6 @@ import (
 	"uni-token-service/store"
 )
 
-// ModelPricing represents the pricing structure for a model
-type ModelPricing struct {
-	PromptRate float64 // USD per 1K tokens
-	OutputRate float64 // USD per 1K tokens
-}
-
-// GetModelPricing returns pricing information for a given model
-func GetModelPricing(model string) ModelPricing {
-	modelLower := strings.ToLower(model)
-
-	switch {
-	case strings.Contains(modelLower, "gpt-4o"):
-		return ModelPricing{
-			PromptRate: 0.005, // $0.005 per 1K prompt tokens
-			OutputRate: 0.015, // $0.015 per 1K output tokens
-		}
-	case strings.Contains(modelLower, "gpt-4"):
-		return ModelPricing{
-			PromptRate: 0.03, // $0.03 per 1K prompt tokens
-			OutputRate: 0.06, // $0.06 per 1K output tokens
-		}
-	case strings.Contains(modelLower, "gpt-3.5"):
-		return ModelPricing{
-			PromptRate: 0.0015, // $0.0015 per 1K prompt tokens
-			OutputRate: 0.002,  // $0.002 per 1K output tokens
-		}
-	case strings.Contains(modelLower, "claude"):
-		return ModelPricing{
-			PromptRate: 0.008, // $0.008 per 1K prompt tokens
-			OutputRate: 0.024, // $0.024 per 1K output tokens
-		}
-	default:
-		return ModelPricing{
-			PromptRate: 0.001, // Default rate
-			OutputRate: 0.002,
-		}
-	}
-}
-
-// CalculateCost calculates the cost based on model and token usage
-func CalculateCost(model string, promptTokens, outputTokens int) float64 {
-	pricing := GetModelPricing(model)
-
-	promptCost := float64(promptTokens) / 1000.0 * pricing.PromptRate
-	outputCost := float64(outputTokens) / 1000.0 * pricing.OutputRate
-
-	return promptCost + outputCost
-}
-
 // ExtractModelFromRequest extracts model name from request body
 func ExtractModelFromRequest(requestBody []byte) string {
 	if len(requestBody) == 0 {
@@ -75,65 +26,126 @@ func ExtractModelFromRequest(requestBody []byte) string {
 
 // UsageData represents token usage information
 type UsageData struct {
-	PromptTokens int
-	OutputTokens int
-	Cost         float64
-	Model        string
+	PromptTokens    int
+	CachedTokens    int // Part of PromptTokens read from the prompt cache
+	OutputTokens    int
+	ReasoningTokens int // Part of OutputTokens spent on reasoning
+	Images          int
+	Cost            float64
+	Model           string
 }
 
-// ExtractUsageFromResponse extracts token usage from API response
-func ExtractUsageFromResponse(responseBody []byte) UsageData {
+// ExtractUsageFromResponse extracts token usage from an API response served by a key
+func ExtractUsageFromResponse(key store.LLMKey, responseBody []byte) UsageData {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(responseBody, &resp); err != nil {
 		return UsageData{}
 	}
 
-	usage, ok := resp["usage"].(map[string]interface{})
-	if !ok {
-		return UsageData{}
+	var data UsageData
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		data = parseUsage(usage)
+	}
+	data.Images = countImages(resp)
+	if data == (UsageData{}) {
+		return data
 	}
 
-	var promptTokens, outputTokens int
-	if prompt, ok := usage["prompt_tokens"].(float64); ok {
-		promptTokens = int(prompt)
+	// Get model from response or use default
+	data.Model = "unknown"
+	if modelStr, ok := resp["model"].(string); ok {
+		data.Model = modelStr
 	}
 
-	if completion, ok := usage["completion_tokens"].(float64); ok {
-		outputTokens = int(completion)
+	data.Cost = CalculateCost(key, data.Model, data)
+	return data
+}
+
+// parseUsage reads token counts from a usage object in either the OpenAI or
+// the Anthropic format. Anthropic reports cached input separately, so cache
+// reads and writes are added to the prompt tokens.
+func parseUsage(usage map[string]interface{}) UsageData {
+	var data UsageData
+	if prompt, ok := usage["prompt_tokens"].(float64); ok {
+		data.PromptTokens = int(prompt)
+		if details, ok := usage["prompt_tokens_details"].(map[string]interface{}); ok {
+			if cached, ok := details["cached_tokens"].(float64); ok {
+				data.CachedTokens = int(cached)
+			}
+		}
+	} else if input, ok := usage["input_tokens"].(float64); ok {
+		data.PromptTokens = int(input)
+		if cacheRead, ok := usage["cache_read_input_tokens"].(float64); ok {
+			data.PromptTokens += int(cacheRead)
+			data.CachedTokens = int(cacheRead)
+		}
+		if cacheCreation, ok := usage["cache_creation_input_tokens"].(float64); ok {
+			data.PromptTokens += int(cacheCreation)
+		}
+		// OpenAI Responses API
+		if details, ok := usage["input_tokens_details"].(map[string]interface{}); ok {
+			if cached, ok := details["cached_tokens"].(float64); ok {
+				data.CachedTokens = int(cached)
+			}
+		}
 	}
 
-	// Get model from response or use default
-	model := "unknown"
-	if modelStr, ok := resp["model"].(string); ok {
-		model = modelStr
+	if completion, ok := usage["completion_tokens"].(float64); ok {
+		data.OutputTokens = int(completion)
+		if details, ok := usage["completion_tokens_details"].(map[string]interface{}); ok {
+			if reasoning, ok := details["reasoning_tokens"].(float64); ok {
+				data.ReasoningTokens = int(reasoning)
+			}
+		}
+	} else if output, ok := usage["output_tokens"].(float64); ok {
+		data.OutputTokens = int(output)
+		if details, ok := usage["output_tokens_details"].(map[string]interface{}); ok {
+			if reasoning, ok := details["reasoning_tokens"].(float64); ok {
+				data.ReasoningTokens = int(reasoning)
+			}
+		}
 	}
 
-	cost := CalculateCost(model, promptTokens, outputTokens)
+	return data
+}
 
-	return UsageData{
-		PromptTokens: promptTokens,
-		OutputTokens: outputTokens,
-		Cost:         cost,
-		Model:        model,
+// countImages counts the images returned by an image generation response
+func countImages(resp map[string]interface{}) int {
+	items, ok := resp["data"].([]interface{})
+	if !ok {
+		return 0
+	}
+	count := 0
+	for _, item := range items {
+		image, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := image["b64_json"]; ok {
+			count++
+		} else if _, ok := image["url"]; ok {
+			count++
+		}
 	}
+	return count
 }
 
 // RecordUsage records token usage to the store
-func RecordUsage(appID, appName, key, model, endpoint string, promptTokens, outputTokens int, cost float64, status string) error {
-	return store.RecordUsage(appID, appName, key, model, endpoint, promptTokens, outputTokens, cost, status)
+func RecordUsage(appID, appName, keyID, key, model, endpoint string, promptTokens, outputTokens int, cost float64, status string) error {
+	return store.RecordUsage(appID, appName, keyID, key, model, endpoint, promptTokens, outputTokens, cost, status)
 }
 
 // StreamingUsageExtractor extracts usage data from streaming responses
 type StreamingUsageExtractor struct {
-	AppID        string
-	AppName      string
-	Key          string
-	Model        string
-	Endpoint     string
-	PromptTokens int
-	OutputTokens int
-	TotalTokens  int
-	buffer       string
+	AppID    string
+	AppName  string
+	KeyID    string
+	Key      string
+	Model    string
+	Endpoint string
+	Usage    UsageData
+	provider store.LLMKey
+	buffer   string
 }
 
 // NewStreamingUsageExtractor creates a new streaming usage extractor
@@ -149,63 +161,79 @@ func (s *StreamingUsageExtractor) ProcessChunk(chunk []byte) {
 
 	// Look for usage information in SSE format
 	lines := strings.Split(s.buffer, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "data: ") {
-			dataStr := strings.TrimPrefix(line, "data: ")
-			if dataStr == "[DONE]" {
-				continue
-			}
+	for _, line := range lines[:len(lines)-1] {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		dataStr := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if dataStr == "[DONE]" {
+			continue
+		}
 
-			var data map[string]interface{}
-			if err := json.Unmarshal([]byte(dataStr), &data); err == nil {
-				// Extract usage from streaming chunk
-				if usage, ok := data["usage"].(map[string]interface{}); ok {
-					if promptTokens, ok := usage["prompt_tokens"].(float64); ok {
-						s.PromptTokens = int(promptTokens)
-					}
-					if completionTokens, ok := usage["completion_tokens"].(float64); ok {
-						s.OutputTokens = int(completionTokens)
-					}
-					if totalTokens, ok := usage["total_tokens"].(float64); ok {
-						s.TotalTokens = int(totalTokens)
-					}
-				}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
+			continue
+		}
 
-				// Update model if available in streaming response
-				if model, ok := data["model"].(string); ok && model != "" {
+		switch data["type"] {
+		case "message_start":
+			// Anthropic: the initial message carries the model and input usage
+			if message, ok := data["message"].(map[string]interface{}); ok {
+				if model, ok := message["model"].(string); ok && model != "" {
 					s.Model = model
 				}
+				if usage, ok := message["usage"].(map[string]interface{}); ok {
+					s.Usage = parseUsage(usage)
+				}
+			}
+		case "message_delta":
+			// Anthropic: output usage is cumulative, input usage is optional
+			if usage, ok := data["usage"].(map[string]interface{}); ok {
+				delta := parseUsage(usage)
+				if delta.PromptTokens > 0 {
+					s.Usage.PromptTokens = delta.PromptTokens
+					s.Usage.CachedTokens = delta.CachedTokens
+				}
+				s.Usage.OutputTokens = delta.OutputTokens
+			}
+		default:
+			// Extract usage from streaming chunk
+			if usage, ok := data["usage"].(map[string]interface{}); ok {
+				s.Usage = parseUsage(usage)
+			}
+
+			// Update model if available in streaming response
+			if model, ok := data["model"].(string); ok && model != "" {
+				s.Model = model
 			}
 		}
 	}
 
 	// Keep last incomplete line in buffer
-	if len(lines) > 0 {
-		s.buffer = lines[len(lines)-1]
-	}
+	s.buffer = lines[len(lines)-1]
 }
 
 // RecordUsage records the collected usage data for streaming
 func (s *StreamingUsageExtractor) RecordUsage(status string) error {
-	cost := CalculateCost(s.Model, s.PromptTokens, s.OutputTokens)
-	return RecordUsage(s.AppID, s.AppName, s.Key, s.Model, s.Endpoint, s.PromptTokens, s.OutputTokens, cost, status)
+	usage := s.GetUsageData()
+	return RecordUsage(s.AppID, s.AppName, s.KeyID, s.Key, s.Model, s.Endpoint, usage.PromptTokens, usage.OutputTokens, usage.Cost, status)
 }
 
 // SetContext sets the context information for the streaming extractor
-func (s *StreamingUsageExtractor) SetContext(appID, appName, key, endpoint string) {
+func (s *StreamingUsageExtractor) SetContext(appID, appName string, key store.LLMKey, endpoint string) {
 	s.AppID = appID
 	s.AppName = appName
-	s.Key = key
+	s.KeyID = key.ID
+	s.Key = key.Name
 	s.Endpoint = endpoint
+	s.provider = key
 }
 
 // GetUsageData returns the collected usage data
 func (s *StreamingUsageExtractor) GetUsageData() UsageData {
-	cost := CalculateCost(s.Model, s.PromptTokens, s.OutputTokens)
-	return UsageData{
-		PromptTokens: s.PromptTokens,
-		OutputTokens: s.OutputTokens,
-		Cost:         cost,
-		Model:        s.Model,
-	}
+	usage := s.Usage
+	usage.Model = s.Model
+	usage.Cost = CalculateCost(s.provider, s.Model, usage)
+	return usage
 }
@@ -0,0 +1,10 @@
+package userService
+
+// Options describe how the service is started for the current user
+type Options struct {
+	Name           string
+	Description    string
+	ExecutablePath string
+	Arguments      []string
+	EnvVars        map[string]string
+}
@@ -0,0 +1,191 @@
+//go:build linux
+
+package userService
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Available reports whether the service can be installed without elevation:
+// either a systemd user manager is reachable, or a desktop session will run
+// XDG autostart entries.
+func Available() bool {
+	return hasSystemdUser() || hasDesktopSession()
+}
+
+// IsInstalled reports whether a per-user unit or autostart entry exists
+func IsInstalled(name string) bool {
+	for _, path := range []string{unitPath(name), autostartPath(name)} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Install registers the service to start with the user's session and starts
+// it now. A systemd user unit is preferred, it restarts the service if it
+// crashes.
+func Install(options Options) error {
+	if hasSystemdUser() {
+		return installSystemdUnit(options)
+	}
+	return installAutostart(options)
+}
+
+// Uninstall stops the service and removes whichever entry was installed
+func Uninstall(name string) error {
+	if _, err := os.Stat(unitPath(name)); err == nil {
+		// Stopping fails when the unit is not running, which is fine
+		systemctl("disable", "--now", name+".service")
+		if err := os.Remove(unitPath(name)); err != nil {
+			return err
+		}
+		return systemctl("daemon-reload")
+	}
+	if err := os.Remove(autostartPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func installSystemdUnit(options Options) error {
+	var env strings.Builder
+	for _, key := range sortedKeys(options.EnvVars) {
+		fmt.Fprintf(&env, "Environment=%s\n", systemdQuote(key+"="+options.EnvVars[key]))
+	}
+	// ExecStart also expands variables, unlike Environment
+	command := []string{strings.ReplaceAll(systemdQuote(options.ExecutablePath), "$", "$$")}
+	for _, arg := range options.Arguments {
+		command = append(command, strings.ReplaceAll(systemdQuote(arg), "$", "$$"))
+	}
+
+	unit := fmt.Sprintf(`[Unit]
+Description=%s
+
+[Service]
+ExecStart=%s
+%sRestart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=default.target
+`, options.Description, strings.Join(command, " "), env.String())
+
+	path := unitPath(options.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", "--now", options.Name+".service")
+}
+
+func installAutostart(options Options) error {
+	command := []string{"env"}
+	for _, key := range sortedKeys(options.EnvVars) {
+		command = append(command, desktopQuote(key+"="+options.EnvVars[key]))
+	}
+	command = append(command, desktopQuote(options.ExecutablePath))
+	for _, arg := range options.Arguments {
+		command = append(command, desktopQuote(arg))
+	}
+
+	entry := fmt.Sprintf(`[Desktop Entry]
+Type=Application
+Name=%s
+Comment=%s
+Exec=%s
+Terminal=false
+NoDisplay=true
+X-GNOME-Autostart-enabled=true
+`, options.Name, options.Description, strings.Join(command, " "))
+
+	path := autostartPath(options.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(entry), 0644); err != nil {
+		return err
+	}
+
+	// Autostart only applies to the next login, so start it for this session too
+	cmd := exec.Command(options.ExecutablePath, options.Arguments...)
+	cmd.Env = os.Environ()
+	for key, value := range options.EnvVars {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func hasSystemdUser() bool {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return false
+	}
+	return exec.Command("systemctl", "--user", "show-environment").Run() == nil
+}
+
+func hasDesktopSession() bool {
+	return os.Getenv("XDG_CURRENT_DESKTOP") != "" || os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("DISPLAY") != ""
+}
+
+func systemctl(args ...string) error {
+	output, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl --user %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func configHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config")
+}
+
+func unitPath(name string) string {
+	return filepath.Join(configHome(), "systemd", "user", name+".service")
+}
+
+func autostartPath(name string) string {
+	return filepath.Join(configHome(), "autostart", name+".desktop")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// systemdQuote quotes a word for ExecStart and Environment lines
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "%", "%%")
+	return `"` + s + `"`
+}
+
+// desktopQuote quotes an argument of a desktop entry Exec key
+func desktopQuote(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\\\`, `"`, `\\"`, "`", "\\\\`", "$", `\\$`, "%", "%%")
+	return `"` + replacer.Replace(s) + `"`
+}
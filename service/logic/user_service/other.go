@@ -0,0 +1,24 @@
+//go:build !linux
+
+package userService
+
+import "errors"
+
+var errNotSupported = errors.New("per-user install is not supported on this platform")
+
+// Available reports whether the service can be installed without elevation
+func Available() bool {
+	return false
+}
+
+func IsInstalled(name string) bool {
+	return false
+}
+
+func Install(options Options) error {
+	return errNotSupported
+}
+
+func Uninstall(name string) error {
+	return errNotSupported
+}
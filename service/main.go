@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -9,14 +10,19 @@ import (
 
 	"github.com/kardianos/service"
 
+	"uni-token-service/cli"
 	"uni-token-service/constants"
 	"uni-token-service/discovery"
 	"uni-token-service/logic"
 	"uni-token-service/logic/url_scheme"
+	"uni-token-service/logic/user_service"
 	"uni-token-service/server"
 	"uni-token-service/store"
 )
 
+// How long running requests may take to finish when the service stops
+const shutdownTimeout = 15 * time.Second
+
 const (
 	serviceNamePrefix  = "UniTokenService"
 	serviceDisplayName = "UniToken Service"
@@ -24,25 +30,43 @@ const (
 )
 
 type program struct {
-	exit   chan struct{}
-	logger service.Logger
-	port   int
+	exit    chan struct{}
+	stopped chan struct{}
+	logger  service.Logger
+	port    int
+	lock    *discovery.InstanceLock
 }
 
 func (p *program) Start(s service.Service) error {
 	p.logger.Infof("Service '%s' is starting...", serviceDisplayName)
+
+	// Fail the start, so the service manager sees that another instance runs
+	lock, err := discovery.AcquireInstanceLock()
+	if err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	p.lock = lock
 	p.exit = make(chan struct{})
+	p.stopped = make(chan struct{})
 
 	go p.run()
 	return nil
 }
 
 func (p *program) run() {
+	defer close(p.stopped)
+	defer p.lock.Release()
+
 	store.Init(discovery.GetDbPath())
+	defer func() {
+		if err := store.Close(); err != nil {
+			p.logger.Errorf("Failed to close database: %v", err)
+		}
+	}()
 
 	p.logger.Info("Service is running. Starting main logic...")
 
-	port, err := server.SetupAPIServer()
+	apiServer, port, err := server.SetupAPIServer()
 	if err != nil {
 		p.logger.Errorf("Failed to setup API server: %v", err)
 		return
@@ -51,20 +75,29 @@ func (p *program) run() {
 
 	time.Sleep(100 * time.Millisecond)
 
-	if err := discovery.SetupFileDiscovery(port); err != nil {
+	if err := discovery.SetupFileDiscovery(port, logic.ServerSocket); err != nil {
 		p.logger.Errorf("Failed to setup file discovery: %v", err)
+		server.Shutdown(apiServer, shutdownTimeout)
 		return
 	}
 
 	p.logger.Infof("Service started successfully on port %d", port)
 
 	<-p.exit
+	// Stop advertising the service before draining, so new clients do not pick it up
+	if err := discovery.RemoveFileDiscovery(); err != nil {
+		p.logger.Warningf("Failed to remove discovery file: %v", err)
+	}
+	if err := server.Shutdown(apiServer, shutdownTimeout); err != nil {
+		p.logger.Warningf("Requests were still running at shutdown: %v", err)
+	}
 	p.logger.Info("Service main logic stopped.")
 }
 
 func (p *program) Stop(s service.Service) error {
 	p.logger.Info("Service is stopping...")
 	close(p.exit)
+	<-p.stopped
 	return nil
 }
 
@@ -95,22 +128,28 @@ func main() {
 	}
 
 	if len(os.Args) < 2 {
-		handleSetup()
+		handleSetup(s, svcConfig)
 		logic.OpenUI("/", url.Values{}, false)
 		return
 	}
 
 	command := os.Args[1]
 	commandHandlers := map[string]func(){
-		"run":               func() { mustRun(s.Run()) },
-		"debug":             func() { mustRun(s.Run()) },
+		"run":               func() { runService(s, prg.logger) },
+		"debug":             func() { runService(s, prg.logger) },
 		"version":           func() { fmt.Println(constants.Version) },
-		"url":               func() { handleUrlScheme(os.Args[2]) },
-		"setup":             handleSetup,
+		"url":               func() { handleUrlScheme(s, svcConfig, os.Args[2]) },
+		"setup":             func() { handleSetup(s, svcConfig) },
 		"install-and-start": func() { handleInstallAndStart(&s, serviceName) },
-		"uninstall":         func() { handleSudo(false, []string{"uninstall-impl"}) },
+		"uninstall":         func() { handleUninstallCommand(s, serviceName) },
 		"uninstall-impl":    func() { handleUninstall(s, serviceName) },
 		"sudo":              func() { handleSudoCommand() },
+		"usage":             func() { cli.HandleUsage(os.Args[2:]) },
+		"status":            func() { cli.HandleStatus(os.Args[2:]) },
+		"doctor":            func() { cli.HandleDoctor(os.Args[2:]) },
+		"keys":              func() { cli.HandleKeys(os.Args[2:]) },
+		"apps":              func() { cli.HandleApps(os.Args[2:]) },
+		"users":             func() { cli.HandleUsers(os.Args[2:]) },
 	}
 
 	if handler, exists := commandHandlers[command]; exists {
@@ -130,13 +169,22 @@ func handleSudoCommand() {
 	handleSudo(false, os.Args[2:])
 }
 
+// runService runs the service until it is stopped. A failed start, such as
+// another instance holding the lock, is reported without a panic.
+func runService(s service.Service, logger service.Logger) {
+	if err := s.Run(); err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+}
+
 func mustRun(err error) {
 	if err != nil {
 		panic(err)
 	}
 }
 
-func handleSetup() {
+func handleSetup(s service.Service, svcConfig *service.Config) {
 	err := discovery.InstallExecutable()
 	if err != nil {
 		panic(err)
@@ -157,6 +205,11 @@ func handleSetup() {
 		panic(err)
 	}
 
+	if useUserService(s) {
+		handleUserInstall(svcConfig)
+		return
+	}
+
 	fmt.Printf("Sudo is required to install and start the service.\n")
 
 	// Install and start the service in sudo mode
@@ -199,12 +252,12 @@ func handleSudo(useInstalled bool, args []string) {
 	}
 }
 
-func handleUrlScheme(url string) {
+func handleUrlScheme(s service.Service, svcConfig *service.Config, url string) {
 	url = strings.TrimPrefix(url, "uni-token://")
 
 	switch url {
 	case "start":
-		handleSetup()
+		handleSetup(s, svcConfig)
 	default:
 		fmt.Printf("Unknown URL action: %s\n", url)
 	}
@@ -244,8 +297,78 @@ func handleUninstall(s service.Service, serviceName string) {
 	// 	fmt.Printf("Failed to unregister URL scheme: %v\n", err)
 	// }
 
+	removeServiceRoot()
+}
+
+// useUserService reports whether to install the service for the current user
+// only, which needs no elevation. An existing system service is kept.
+func useUserService(s service.Service) bool {
+	if !userService.Available() {
+		return false
+	}
+	_, err := s.Status()
+	return errors.Is(err, service.ErrNotInstalled)
+}
+
+// systemServiceInstalled reports whether the service is installed system-wide
+func systemServiceInstalled(s service.Service) bool {
+	_, err := s.Status()
+	return err == nil
+}
+
+func handleUserInstall(svcConfig *service.Config) {
+	err := userService.Install(userService.Options{
+		Name:           svcConfig.Name,
+		Description:    svcConfig.Description,
+		ExecutablePath: discovery.GetServiceExecutablePath(),
+		Arguments:      svcConfig.Arguments,
+		EnvVars:        svcConfig.EnvVars,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Installed service \"%s\" for the current user.\n", svcConfig.Name)
+
+	// Callers expect the service to be reachable once setup returns
+	deadline := time.Now().Add(10 * time.Second)
+	for !discovery.IsServiceRunning() && time.Now().Before(deadline) {
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func handleUninstallCommand(s service.Service, serviceName string) {
+	userInstalled := userService.IsInstalled(serviceName)
+	if userInstalled {
+		if err := userService.Uninstall(serviceName); err != nil {
+			fmt.Printf("Failed to uninstall service: %v\n", err)
+		} else {
+			fmt.Printf("Uninstalled service \"%s\".\n", serviceName)
+		}
+
+		// Autostarted copies are not supervised, so stop them directly
+		if info, ok := discovery.GetRunningService(); ok {
+			if process, err := os.FindProcess(info.PID); err == nil && process.Signal(os.Interrupt) == nil {
+				deadline := time.Now().Add(shutdownTimeout + 5*time.Second)
+				for discovery.IsProcessAlive(info.PID) && time.Now().Before(deadline) {
+					time.Sleep(200 * time.Millisecond)
+				}
+				fmt.Printf("Stopped service \"%s\".\n", serviceName)
+			}
+		}
+	}
+
+	// A system service may be installed as well, e.g. from an older version.
+	// Its uninstall removes the service root too.
+	if !userInstalled || systemServiceInstalled(s) {
+		handleSudo(false, []string{"uninstall-impl"})
+		return
+	}
+	removeServiceRoot()
+}
+
+func removeServiceRoot() {
 	rootPath := discovery.GetServiceRootPath()
-	err = os.RemoveAll(rootPath)
+	err := os.RemoveAll(rootPath)
 	if err != nil {
 		fmt.Printf("Failed to remove service root path %s: %v\n", rootPath, err)
 	} else {
@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"uni-token-service/constants"
+	"uni-token-service/discovery"
 	"uni-token-service/logic"
 )
 
@@ -18,6 +19,7 @@ func handleCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"__uni_token": true,
 		"version":     constants.Version,
+		"nonce":       discovery.BootNonce,
 	})
 }
 
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"uni-token-service/logic"
+	peerCred "uni-token-service/logic/peer_cred"
 	"uni-token-service/store"
 
 	"github.com/gin-gonic/gin"
@@ -15,9 +18,21 @@ import (
 
 func SetupAppAPI(router gin.IRouter) {
 	router.POST("/app/register", handleAppRegister)
+
+	api := router.Group("/app").Use(RequireUserLogin())
+	{
+		api.POST("/grant", handleAppGrant)
+		api.POST("/rotate-secret", handleRotateAppSecret)
+		api.POST("/revoke-secret", handleRevokeAppSecret)
+		api.GET("/models", handleGetAppModels)
+		api.POST("/models", handleSetAppModels)
+	}
 }
 
-var waitForGrant = make(map[string]chan<- bool)
+var (
+	waitForGrant   = make(map[string]chan<- bool)
+	waitForGrantMu sync.Mutex
+)
 
 func handleAppRegister(c *gin.Context) {
 	var req struct {
@@ -31,10 +46,24 @@ func handleAppRegister(c *gin.Context) {
 	}
 
 	uid := req.UID
+	presentedToken := ""
+	if appID, _, ok := logic.ParseAppToken(req.UID); ok {
+		// The saved API key is a token issued by an earlier grant
+		uid = appID
+		presentedToken = req.UID
+	}
+	caller := requestCaller(c)
 
+	// granted hands out a fresh secret, which replaces any previous one issued
+	// to the same caller
 	granted := func() {
+		token, err := issueAppToken(uid, caller)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue app secret"})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"token": uid,
+			"token": token,
 		})
 	}
 
@@ -46,22 +75,26 @@ func handleAppRegister(c *gin.Context) {
 		Granted:      false,
 		CreatedAt:    time.Now(),
 		LastActiveAt: time.Now(),
+		Caller:       caller,
 	}
 
 	if uid != "" {
 		app, err := store.Apps.Get(uid)
 		if err == nil {
-			if app.Granted {
-				updateLastActiveTime(uid)
-				granted()
-				return
-			}
-			if app.Key != "" {
-				info.Key = app.Key
-			}
-			if app.CreatedAt != (time.Time{}) {
-				info.CreatedAt = app.CreatedAt
+			if app.Granted && callerMatches(app, caller) {
+				if presentedToken != "" {
+					if _, err := logic.VerifyAppToken(presentedToken, caller); err == nil {
+						updateLastActiveTime(uid, caller)
+						c.JSON(http.StatusOK, gin.H{"token": presentedToken})
+						return
+					}
+				} else if !logic.HasAppSecret(app) {
+					// Apps granted before secrets existed saved their bare ID
+					granted()
+					return
+				}
 			}
+			info = pendingAppInfo(app, req.Name, req.Description)
 		}
 	} else {
 		// Search for same-name app
@@ -73,12 +106,7 @@ func handleAppRegister(c *gin.Context) {
 		for _, app := range apps {
 			if app.Name == req.Name {
 				uid = app.ID
-				if app.Key != "" {
-					info.Key = app.Key
-				}
-				if app.CreatedAt != (time.Time{}) {
-					info.CreatedAt = app.CreatedAt
-				}
+				info = pendingAppInfo(app, req.Name, req.Description)
 				break
 			}
 		}
@@ -92,15 +120,28 @@ func handleAppRegister(c *gin.Context) {
 
 	store.Apps.Put(uid, info)
 
-	channel := make(chan bool)
+	channel := make(chan bool, 1)
+	waitForGrantMu.Lock()
 	waitForGrant[uid] = channel
-	defer delete(waitForGrant, uid)
+	waitForGrantMu.Unlock()
+	defer func() {
+		waitForGrantMu.Lock()
+		delete(waitForGrant, uid)
+		waitForGrantMu.Unlock()
+	}()
 
 	params := url.Values{
 		"appId":          {uid},
 		"appName":        {req.Name},
 		"appDescription": {req.Description},
 	}
+	if caller != nil {
+		params.Set("appExecutable", caller.Executable)
+		params.Set("appUid", strconv.Itoa(caller.UID))
+	}
+	if info.PinExecutable {
+		params.Set("appPinned", "true")
+	}
 	uiActive, cleanup, err := logic.OpenAction("grant-app", params)
 	if cleanup != nil {
 		defer cleanup()
@@ -128,9 +169,188 @@ func handleAppRegister(c *gin.Context) {
 	}
 }
 
-func updateLastActiveTime(appID string) {
+// pendingAppInfo returns an existing app about to be prompted for again. Its
+// grant and secret are left alone, so registering with a known name or ID
+// cannot revoke access the user already granted.
+func pendingAppInfo(app store.AppInfo, name, description string) store.AppInfo {
+	app.Name = name
+	app.Description = description
+	app.LastActiveAt = time.Now()
+	return app
+}
+
+// issueAppToken rotates the caller's secret of a granted app and returns its
+// new token. Tokens held by other callers stay valid.
+func issueAppToken(appID string, caller *store.AppCaller) (string, error) {
+	app, err := store.Apps.Get(appID)
+	if err != nil {
+		return "", err
+	}
+	token, err := logic.IssueAppSecret(&app, caller)
+	if err != nil {
+		return "", err
+	}
+	app.LastActiveAt = time.Now()
+	if caller != nil {
+		app.Caller = caller
+	}
+	return token, store.Apps.Put(appID, app)
+}
+
+// handleAppGrant records the user's decision on an app and answers its
+// pending registration, if any
+func handleAppGrant(c *gin.Context) {
+	var req struct {
+		ID            string `json:"id" binding:"required"`
+		Granted       bool   `json:"granted"`
+		Key           string `json:"key"`
+		PinExecutable *bool  `json:"pinExecutable"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	app, err := store.Apps.Get(req.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "App not found"})
+		return
+	}
+
+	// Denying only answers the pending request. An existing grant stays, as
+	// the request may come from another program reusing the app's name.
+	if req.Granted {
+		app.Granted = true
+		if req.Key != "" {
+			// A chosen key replaces the key pool, which would take precedence
+			app.Key = req.Key
+			app.Keys = nil
+		}
+		if req.PinExecutable != nil {
+			app.PinExecutable = *req.PinExecutable
+		}
+		if err := store.Apps.Put(app.ID, app); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save app"})
+			return
+		}
+	}
+
+	waitForGrantMu.Lock()
+	if channel, ok := waitForGrant[app.ID]; ok {
+		select {
+		case channel <- req.Granted:
+		default:
+		}
+	}
+	waitForGrantMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// handleRotateAppSecret issues a new token for an app. All previous tokens
+// stop working immediately.
+func handleRotateAppSecret(c *gin.Context) {
+	var req struct {
+		ID string `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := logic.RotateAppSecret(req.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"token": token},
+	})
+}
+
+// handleRevokeAppSecret invalidates an app's token until it registers again
+func handleRevokeAppSecret(c *gin.Context) {
+	var req struct {
+		ID string `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := logic.RevokeAppSecret(req.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// handleGetAppModels returns the models the gateway reports to an app
+func handleGetAppModels(c *gin.Context) {
+	app, err := store.Apps.Get(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "App not found"})
+		return
+	}
+
+	models, _ := logic.ListAppModels(app)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models,
+	})
+}
+
+// handleSetAppModels replaces the model allowlist and aliases of an app
+func handleSetAppModels(c *gin.Context) {
+	var req struct {
+		ID            string            `json:"id" binding:"required"`
+		AllowedModels []string          `json:"allowedModels"`
+		ModelAliases  map[string]string `json:"modelAliases"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := logic.SetAppModels(req.ID, req.AllowedModels, req.ModelAliases); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// requestCaller returns the executable and user behind a request made over
+// the Unix socket, or nil when they are unknown
+func requestCaller(c *gin.Context) *store.AppCaller {
+	creds := peerCred.FromContext(c.Request.Context())
+	if creds == nil {
+		return nil
+	}
+	return &store.AppCaller{
+		Executable: creds.Executable,
+		UID:        creds.UID,
+	}
+}
+
+// callerMatches reports whether a grant covers the caller. Pinned apps only
+// accept the executable and user they were granted to.
+func callerMatches(app store.AppInfo, caller *store.AppCaller) bool {
+	if !app.PinExecutable {
+		return true
+	}
+	return caller != nil && app.Caller != nil && *caller == *app.Caller
+}
+
+func updateLastActiveTime(appID string, caller *store.AppCaller) {
 	if app, err := store.Apps.Get(appID); err == nil {
 		app.LastActiveAt = time.Now()
+		if caller != nil {
+			app.Caller = caller
+		}
 		store.Apps.Put(appID, app)
 	}
 }
@@ -1,8 +1,14 @@
 package server
 
 import (
+	"errors"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 
@@ -15,6 +21,11 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
@@ -32,6 +43,10 @@ func SetupAuthAPI(router *gin.Engine) {
 	{
 		auth.POST("/login", handleUserLogin)
 		auth.POST("/register", handleRegister)
+		auth.POST("/logout", RequireUserLogin(), handleLogout)
+		auth.POST("/rotate-secret", RequireUserLogin(), handleRotateJWTSecret)
+		auth.GET("/audit", RequireUserLogin(), handleGetAuthAudit)
+		auth.POST("/password", RequireUserLogin(), handleChangePassword)
 	}
 }
 
@@ -53,23 +68,44 @@ func handleUserLogin(c *gin.Context) {
 		return
 	}
 
-	user, err := store.Users.Get(req.Username)
-	if err != nil {
-		c.JSON(http.StatusOK, AuthResponse{
+	client := clientAddress(c)
+	if wait := logic.CheckLoginAllowed(req.Username, client); wait > 0 {
+		recordAuthEvent(c, store.AuthEventLoginLocked, req.Username, "")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, AuthResponse{
 			Status:  "error",
-			Message: "User not found",
+			Message: "Too many failed login attempts, try again later",
 		})
 		return
 	}
 
-	hashedPassword := logic.HashPassword(req.Password)
-	if user.Password != hashedPassword {
+	user, err := store.Users.Get(req.Username)
+	if err != nil {
+		// Spend the same time as a wrong password, so usernames cannot be probed
+		logic.VerifyPassword(req.Password, getDummyPasswordHash())
+	}
+
+	ok, needsRehash := logic.VerifyPassword(req.Password, user.Password)
+	if err != nil || !ok {
+		logic.RecordLoginFailure(req.Username, client)
+		recordAuthEvent(c, store.AuthEventLoginFailure, req.Username, "")
 		c.JSON(http.StatusOK, AuthResponse{
 			Status:  "error",
 			Message: "Invalid username or password",
 		})
 		return
 	}
+	logic.RecordLoginSuccess(req.Username, client)
+	if err := store.UnlockKeyVault(user.Username, req.Password); err != nil {
+		log.Println("Failed to unlock provider keys:", err)
+	}
+	if needsRehash {
+		// Upgrade hashes from older schemes now that the password is known
+		user.Password = logic.HashPassword(req.Password)
+		if err := store.Users.Put(user.Username, user); err != nil {
+			log.Printf("Failed to upgrade password hash for %s: %v", user.Username, err)
+		}
+	}
 
 	token, err := logic.GenerateJWT(user.Username)
 	if err != nil {
@@ -80,6 +116,7 @@ func handleUserLogin(c *gin.Context) {
 		return
 	}
 
+	recordAuthEvent(c, store.AuthEventLoginSuccess, user.Username, "")
 	c.JSON(http.StatusOK, AuthResponse{
 		Status:   "success",
 		Username: user.Username,
@@ -87,6 +124,23 @@ func handleUserLogin(c *gin.Context) {
 	})
 }
 
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+	registerMu            sync.Mutex
+)
+
+// getDummyPasswordHash hashes on first use, so CLI commands that import this
+// package do not pay for Argon2id at startup
+func getDummyPasswordHash() string {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash = logic.HashPassword("")
+	})
+	return dummyPasswordHash
+}
+
+// handleRegister creates the first account, which becomes the admin. Later
+// accounts can only be created by a logged-in admin.
 func handleRegister(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -97,7 +151,40 @@ func handleRegister(c *gin.Context) {
 		return
 	}
 
-	_, err := store.Users.Get(req.Username)
+	// Serialize registrations so two clients cannot both bootstrap
+	registerMu.Lock()
+	defer registerMu.Unlock()
+
+	count, err := store.Users.Count()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, AuthResponse{
+			Status:  "error",
+			Message: "Failed to register",
+		})
+		return
+	}
+	bootstrap := count == 0
+
+	createdBy := ""
+	if !bootstrap {
+		claims, ok := sessionFromRequest(c)
+		if ok {
+			admin, err := store.Users.Get(claims.Id)
+			if err == nil && admin.Admin {
+				createdBy = admin.Username
+			}
+		}
+		if createdBy == "" {
+			recordAuthEvent(c, store.AuthEventRegisterDenied, req.Username, "")
+			c.JSON(http.StatusForbidden, AuthResponse{
+				Status:  "error",
+				Message: "Registration is closed, ask an admin to create the account",
+			})
+			return
+		}
+	}
+
+	_, err = store.Users.Get(req.Username)
 	if err == nil {
 		c.JSON(http.StatusOK, AuthResponse{
 			Status:  "error",
@@ -110,6 +197,7 @@ func handleRegister(c *gin.Context) {
 	user := store.UserInfo{
 		Username: req.Username,
 		Password: hashedPassword,
+		Admin:    bootstrap,
 	}
 
 	err = store.Users.Put(req.Username, user)
@@ -121,6 +209,16 @@ func handleRegister(c *gin.Context) {
 		return
 	}
 
+	if !bootstrap {
+		// The admin stays logged in as themselves
+		recordAuthEvent(c, store.AuthEventRegister, user.Username, "created by "+createdBy)
+		c.JSON(http.StatusOK, AuthResponse{
+			Status:   "success",
+			Username: user.Username,
+		})
+		return
+	}
+
 	token, err := logic.GenerateJWT(user.Username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, AuthResponse{
@@ -130,6 +228,7 @@ func handleRegister(c *gin.Context) {
 		return
 	}
 
+	recordAuthEvent(c, store.AuthEventRegister, user.Username, "first account")
 	c.JSON(http.StatusOK, AuthResponse{
 		Status:   "success",
 		Username: user.Username,
@@ -137,34 +236,199 @@ func handleRegister(c *gin.Context) {
 	})
 }
 
+// sessionFromRequest returns the claims of a valid, unrevoked session token
+func sessionFromRequest(c *gin.Context) (*logic.JWTClaims, bool) {
+	tokenParts := strings.Split(c.GetHeader("Authorization"), " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return nil, false
+	}
+
+	claims, err := logic.ValidateJWT(tokenParts[1])
+	if err != nil || logic.IsJWTRevoked(claims) {
+		return nil, false
+	}
+	return claims, true
+}
+
 func RequireUserLogin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Method == "OPTIONS" {
 			c.Next()
 			return
 		}
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.Status(http.StatusUnauthorized)
-			c.Abort()
-			return
-		}
 
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			c.Status(http.StatusUnauthorized)
-			c.Abort()
-			return
-		}
-
-		claims, err := logic.ValidateJWT(tokenParts[1])
-		if err != nil {
+		claims, ok := sessionFromRequest(c)
+		if !ok {
 			c.Status(http.StatusUnauthorized)
 			c.Abort()
 			return
 		}
 
 		c.Set("username", claims.Id)
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
+
+// clientAddress identifies the client for throttling and auditing
+func clientAddress(c *gin.Context) string {
+	if isUnixSocketRequest(c.Request) {
+		return "unix"
+	}
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+func recordAuthEvent(c *gin.Context, eventType, username, detail string) {
+	err := store.RecordAuthEvent(store.AuthEvent{
+		Type:       eventType,
+		Username:   username,
+		RemoteAddr: clientAddress(c),
+		Detail:     detail,
+	})
+	if err != nil {
+		log.Println("Failed to record auth event:", err)
+	}
+}
+
+// handleGetAuthAudit returns the most recent auth events
+func handleGetAuthAudit(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	events, err := store.ListAuthEvents(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get auth events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    events,
+	})
+}
+
+// handleLogout revokes the session token used for the request
+func handleLogout(c *gin.Context) {
+	claims := c.MustGet("claims").(*logic.JWTClaims)
+	if err := logic.RevokeJWT(claims); err != nil {
+		c.JSON(http.StatusInternalServerError, AuthResponse{
+			Status:  "error",
+			Message: "Failed to log out",
+		})
+		return
+	}
+	recordAuthEvent(c, store.AuthEventLogout, claims.Id, "")
+
+	c.JSON(http.StatusOK, AuthResponse{
+		Status: "success",
+	})
+}
+
+// handleChangePassword changes the caller's password. Provider keys wrapped
+// with the old password are re-wrapped with the new one. Wrong old passwords
+// count towards the login throttle, and other sessions of the user end.
+func handleChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, AuthResponse{
+			Status:  "error",
+			Message: "Invalid request data",
+		})
+		return
+	}
+
+	username := c.GetString("username")
+	client := clientAddress(c)
+	if wait := logic.CheckLoginAllowed(username, client); wait > 0 {
+		recordAuthEvent(c, store.AuthEventLoginLocked, username, "password change")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, AuthResponse{
+			Status:  "error",
+			Message: "Too many failed attempts, try again later",
+		})
+		return
+	}
+
+	err := logic.ChangePassword(username, req.OldPassword, req.NewPassword)
+	if errors.Is(err, logic.ErrInvalidPassword) {
+		logic.RecordLoginFailure(username, client)
+		recordAuthEvent(c, store.AuthEventLoginFailure, username, "password change")
+		c.JSON(http.StatusForbidden, AuthResponse{
+			Status:  "error",
+			Message: "Invalid password",
+		})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to change password:", err)
+		c.JSON(http.StatusInternalServerError, AuthResponse{
+			Status:  "error",
+			Message: "Failed to change password",
+		})
+		return
+	}
+	logic.RecordLoginSuccess(username, client)
+
+	// Sessions opened with the old password ended, the caller gets a new one
+	recordAuthEvent(c, store.AuthEventPasswordChange, username, "")
+	token, err := logic.GenerateJWT(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, AuthResponse{
+			Status:  "error",
+			Message: "Failed to generate token",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, AuthResponse{
+		Status:   "success",
+		Username: username,
+		Token:    token,
+	})
+}
+
+// handleRotateJWTSecret starts signing sessions with a new key. With
+// revokeSessions, every existing session, including the caller's, ends. It
+// affects every user, so only admins may do it.
+func handleRotateJWTSecret(c *gin.Context) {
+	var req struct {
+		RevokeSessions bool `json:"revokeSessions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, AuthResponse{
+			Status:  "error",
+			Message: "Invalid request data",
+		})
+		return
+	}
+
+	if user, err := store.Users.Get(c.GetString("username")); err != nil || !user.Admin {
+		c.JSON(http.StatusForbidden, AuthResponse{
+			Status:  "error",
+			Message: "Only admins may rotate the session secret",
+		})
+		return
+	}
+
+	if _, err := logic.RotateJWTSecret(req.RevokeSessions); err != nil {
+		c.JSON(http.StatusInternalServerError, AuthResponse{
+			Status:  "error",
+			Message: "Failed to rotate secret",
+		})
+		return
+	}
+	detail := ""
+	if req.RevokeSessions {
+		detail = "sessions revoked"
+	}
+	recordAuthEvent(c, store.AuthEventRotateSecret, c.GetString("username"), detail)
+
+	c.JSON(http.StatusOK, AuthResponse{
+		Status: "success",
+	})
+}
@@ -2,10 +2,14 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"uni-token-service/logic"
 	"uni-token-service/store"
@@ -15,82 +19,284 @@ import (
 
 func SetupGatewayAPI(router *gin.Engine) {
 	router.Any("/openai/*path", handleOpenAIProxy)
+	router.Any("/anthropic/*path", handleAnthropicProxy)
 }
 
 func handleOpenAIProxy(c *gin.Context) {
-	path := c.Param("path")
-	appId := ensureToken(c)
+	handleGatewayProxy(c, logic.ProtocolOpenAI)
+}
+
+func handleAnthropicProxy(c *gin.Context) {
+	handleGatewayProxy(c, logic.ProtocolAnthropic)
+}
+
+// gatewayRequest holds the state of one client request while it is tried
+// against the app's keys.
+type gatewayRequest struct {
+	protocol string
+	path     string
+	appId    string
+	appInfo  store.AppInfo
+	body     []byte
+	model    string
+}
+
+// gatewayTarget describes how a request is sent to one particular key
+type gatewayTarget struct {
+	key         store.LLMKey
+	keyProtocol string
+	translate   bool
+	started     time.Time // when the request was sent to this key
+}
 
-	appInfo, err := store.Apps.Get(appId)
+func handleGatewayProxy(c *gin.Context, protocol string) {
+	token := ensureToken(c, protocol)
+	if token == "" {
+		return
+	}
+
+	appInfo, err := logic.VerifyAppToken(token, requestCaller(c))
+	if errors.Is(err, logic.ErrAppCallerNotAllowed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "App is pinned to another executable"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get app info"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid app token"})
 		return
 	}
+	appId := appInfo.ID
 
 	if !appInfo.Granted {
 		c.JSON(http.StatusForbidden, gin.H{"error": "App access not granted"})
 		return
 	}
 
-	key, err := store.LLMKeys.Get(appInfo.Key)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve key"})
+	// The gateway knows which models it can route, so it answers model listings
+	// itself. Without any model list it proxies them like other requests.
+	if protocol == logic.ProtocolOpenAI && c.Request.Method == http.MethodGet && logic.IsModelsPath(c.Param("path")) {
+		if models, known := logic.ListAppModels(appInfo); known {
+			c.JSON(http.StatusOK, gin.H{
+				"object": "list",
+				"data":   models,
+			})
+			return
+		}
+	}
+
+	// Requests to providers end with the client's request, or at a forced shutdown
+	ctx, cancel := upstreamContext(c.Request.Context())
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+
+	if err := logic.CheckAppBudget(appInfo); err != nil {
+		respondBudgetError(c, err)
 		return
 	}
 
-	// Build target URL
-	targetURL, err := url.JoinPath(key.BaseURL, path)
+	releaseApp, err := logic.AcquireAppRateLimit(c.Request.Context(), appInfo)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build target URL"})
+		respondRateLimitError(c, err)
 		return
 	}
-
-	if c.Request.URL.RawQuery != "" {
-		targetURL += "?" + c.Request.URL.RawQuery
+	tokensUsed := 0
+	defer func() { releaseApp(tokensUsed) }()
+
+	gr := &gatewayRequest{
+		protocol: protocol,
+		path:     c.Param("path"),
+		appId:    appId,
+		appInfo:  appInfo,
 	}
 
-	// Read request body for usage tracking
-	var requestBody []byte
+	// Read request body for usage tracking and retries
 	if c.Request.Body != nil {
-		requestBody, _ = io.ReadAll(c.Request.Body)
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		gr.body, _ = io.ReadAll(c.Request.Body)
 	}
 
 	// Extract model from request for usage tracking
-	model := logic.ExtractModelFromRequest(requestBody)
+	gr.model = logic.ExtractModelFromRequest(gr.body)
+	if gr.model != "unknown" {
+		model, err := logic.ResolveAppModel(appInfo, gr.model)
+		if err != nil {
+			respondOpenAIError(c, http.StatusForbidden, "The model '"+gr.model+"' is not allowed for this app", "invalid_request_error", "model_not_allowed")
+			return
+		}
+		if model != gr.model {
+			if gr.body, err = logic.ReplaceRequestModel(gr.body, model); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			gr.model = model
+		}
+	}
 
-	// Create new request
-	req, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+	targets, budgetErr := gr.resolveTargets()
+	if len(targets) == 0 {
+		if budgetErr != nil {
+			respondBudgetError(c, budgetErr)
+			return
+		}
+		message := "No granted key supports the " + protocol + " protocol"
+		if gr.model != "unknown" {
+			message += " and the model " + gr.model
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
 		return
 	}
 
-	// Copy all headers except Authorization
-	for key, values := range c.Request.Header {
-		if strings.ToLower(key) != "authorization" {
-			for _, value := range values {
-				req.Header.Add(key, value)
+	for i, target := range targets {
+		isLast := i == len(targets)-1
+
+		// Only queue on the last key, earlier ones fail over immediately
+		releaseKey, err := logic.AcquireKeyRateLimit(c.Request.Context(), target.key, isLast)
+		if err != nil {
+			if isLast {
+				respondRateLimitError(c, err)
+				return
+			}
+			continue
+		}
+
+		req, err := gr.newUpstreamRequest(c, target)
+		if err != nil {
+			releaseKey(0)
+			if errors.Is(err, store.ErrKeysLocked) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Create HTTP client with no timeout for streaming
+		client := &http.Client{
+			Timeout: 0, // No timeout for streaming
+		}
+
+		target.started = time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			// Record failed request
+			releaseKey(0)
+			gr.recordError(target, 0)
+			if c.Request.Context().Err() != nil {
+				// The client went away or the service is shutting down, not the key's fault
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request cancelled"})
+				return
+			}
+			logic.MarkKeyUnavailable(target.key.ID, 0)
+			if isLast {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to proxy request"})
+				return
 			}
+			continue
 		}
+
+		if !isLast && logic.IsRetryableStatus(resp.StatusCode) {
+			// Try the next key, and keep this one aside until the provider is ready again
+			resp.Body.Close()
+			releaseKey(0)
+			gr.recordError(target, resp.StatusCode)
+			logic.MarkKeyUnavailable(target.key.ID, logic.ParseRetryAfter(resp.Header.Get("Retry-After")))
+			continue
+		}
+
+		tokensUsed = gr.forwardResponse(c, target, resp)
+		releaseKey(tokensUsed)
+		resp.Body.Close()
+		return
 	}
+}
 
-	// Set authorization header with key token
-	req.Header.Set("Authorization", "Bearer "+key.Token)
+// resolveTargets lists the keys that can serve the request, in the order they
+// should be tried. Keys over budget are skipped, and the last budget error is
+// returned so it can be reported when no key is left.
+func (gr *gatewayRequest) resolveTargets() ([]gatewayTarget, error) {
+	var targets []gatewayTarget
+	var budgetErr error
+	for _, key := range logic.ResolveAppKeys(gr.appInfo) {
+		target := gatewayTarget{
+			key:         key,
+			keyProtocol: logic.KeyProtocol(key),
+		}
+		// OpenAI chat completions can be served by Anthropic keys via translation
+		target.translate = gr.protocol == logic.ProtocolOpenAI && target.keyProtocol == logic.ProtocolAnthropic &&
+			logic.IsChatCompletionsPath(gr.path)
+		if target.keyProtocol != gr.protocol && !target.translate {
+			continue
+		}
+		if gr.model != "unknown" && !logic.KeyServesModel(key, gr.model) {
+			continue
+		}
+		if err := logic.CheckKeyBudget(key); err != nil {
+			budgetErr = err
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets, budgetErr
+}
 
-	// Create HTTP client with no timeout for streaming
-	client := &http.Client{
-		Timeout: 0, // No timeout for streaming
+func (gr *gatewayRequest) newUpstreamRequest(c *gin.Context, target gatewayTarget) (*http.Request, error) {
+	// Build target URL
+	var targetURL string
+	var err error
+	if target.translate {
+		targetURL, err = logic.AnthropicMessagesURL(target.key.BaseURL)
+	} else {
+		targetURL, err = url.JoinPath(target.key.BaseURL, gr.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Request.URL.RawQuery != "" {
+		targetURL += "?" + c.Request.URL.RawQuery
 	}
 
-	resp, err := client.Do(req)
+	body := gr.body
+	if target.translate {
+		body, err = logic.OpenAIToAnthropicRequest(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create new request
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, targetURL, bytes.NewReader(body))
 	if err != nil {
-		// Record failed request
-		logic.RecordUsage(appId, appInfo.Name, key.Name, model, path, 0, 0, 0, "error")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to proxy request"})
-		return
+		return nil, err
+	}
+
+	// Copy all headers except credentials
+	for key, values := range c.Request.Header {
+		if isCredentialHeader(key) {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	// Set credentials with key token
+	token, err := store.OpenLLMKey(target.key)
+	if err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
+	logic.SetUpstreamAuth(req, target.keyProtocol, token)
+
+	if target.translate {
+		// Translated bodies must be readable, so let the transport handle compression
+		req.Header.Del("Accept-Encoding")
+	}
+
+	return req, nil
+}
+
+// forwardResponse relays the upstream response to the client, records usage
+// and returns the number of tokens the request used.
+func (gr *gatewayRequest) forwardResponse(c *gin.Context, target gatewayTarget, resp *http.Response) int {
+	key := target.key
 
 	// Check if response is streaming (Server-Sent Events)
 	contentType := resp.Header.Get("Content-Type")
@@ -104,6 +310,10 @@ func handleOpenAIProxy(c *gin.Context) {
 		}
 	}
 
+	if target.translate {
+		c.Writer.Header().Del("Content-Length")
+	}
+
 	// Set status code
 	c.Status(resp.StatusCode)
 
@@ -113,25 +323,42 @@ func handleOpenAIProxy(c *gin.Context) {
 		c.Writer.Flush()
 
 		// Create usage extractor for streaming
-		usageExtractor := logic.NewStreamingUsageExtractor(model)
-		usageExtractor.SetContext(appId, appInfo.Name, key.Name, path)
+		usageExtractor := logic.NewStreamingUsageExtractor(gr.model)
+		usageExtractor.SetContext(gr.appId, gr.appInfo.Name, key, gr.path)
+
+		var translator *logic.AnthropicStreamTranslator
+		if target.translate {
+			translator = logic.NewAnthropicStreamTranslator(logic.WantsStreamUsage(gr.body))
+		}
 
 		// Stream response body
+		var timeToFirstToken time.Duration
+		interrupted := false
 		buffer := make([]byte, 4096)
 		for {
 			n, err := resp.Body.Read(buffer)
 			if n > 0 {
+				if timeToFirstToken == 0 {
+					timeToFirstToken = time.Since(target.started)
+				}
+
 				// Extract usage from streaming chunks
 				usageExtractor.ProcessChunk(buffer[:n])
 
-				if _, writeErr := c.Writer.Write(buffer[:n]); writeErr != nil {
+				output := buffer[:n]
+				if translator != nil {
+					output = translator.ProcessChunk(output)
+				}
+
+				if _, writeErr := c.Writer.Write(output); writeErr != nil {
 					break
 				}
 				c.Writer.Flush()
 			}
 			if err != nil {
 				if err != io.EOF {
-					// Log error but don't return JSON as we're already streaming
+					// Don't return JSON as we're already streaming, e.g. cut off by shutdown
+					interrupted = true
 				}
 				break
 			}
@@ -139,23 +366,36 @@ func handleOpenAIProxy(c *gin.Context) {
 
 		// Record streaming usage
 		status := "success"
-		if resp.StatusCode >= 400 {
+		if resp.StatusCode >= 400 || interrupted {
 			status = "error"
 		}
 
 		usageExtractor.RecordUsage(status)
+		usage := usageExtractor.GetUsageData()
+		logic.ObserveGatewayRequest(logic.GatewayObservation{
+			App:              gr.appInfo.Name,
+			Key:              key.Name,
+			Model:            usage.Model,
+			StatusCode:       resp.StatusCode,
+			Duration:         time.Since(target.started),
+			TimeToFirstToken: timeToFirstToken,
+			PromptTokens:     usage.PromptTokens,
+			OutputTokens:     usage.OutputTokens,
+			Cost:             usage.Cost,
+		})
+		return usage.PromptTokens + usage.OutputTokens
 	} else {
 		// Handle non-streaming response
 		responseBody, err := io.ReadAll(resp.Body)
 		if err != nil {
 			// Record failed request
-			logic.RecordUsage(appId, appInfo.Name, key.Name, model, path, 0, 0, 0, "error")
+			gr.recordError(target, resp.StatusCode)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response"})
-			return
+			return 0
 		}
 
 		// Extract usage from response
-		usageData := logic.ExtractUsageFromResponse(responseBody)
+		usageData := logic.ExtractUsageFromResponse(key, responseBody)
 
 		// Record usage
 		status := "success"
@@ -164,25 +404,108 @@ func handleOpenAIProxy(c *gin.Context) {
 		}
 
 		// Use model from response if available, otherwise use request model
-		finalModel := model
-		if usageData.Model != "unknown" {
+		finalModel := gr.model
+		if usageData.Model != "" && usageData.Model != "unknown" {
 			finalModel = usageData.Model
 		}
 
-		logic.RecordUsage(appId, appInfo.Name, key.Name, finalModel, path,
+		logic.RecordUsage(gr.appId, gr.appInfo.Name, key.ID, key.Name, finalModel, gr.path,
 			usageData.PromptTokens, usageData.OutputTokens, usageData.Cost, status)
+		logic.ObserveGatewayRequest(logic.GatewayObservation{
+			App:          gr.appInfo.Name,
+			Key:          key.Name,
+			Model:        finalModel,
+			StatusCode:   resp.StatusCode,
+			Duration:     time.Since(target.started),
+			PromptTokens: usageData.PromptTokens,
+			OutputTokens: usageData.OutputTokens,
+			Cost:         usageData.Cost,
+		})
+
+		if target.translate {
+			if translated, err := logic.AnthropicToOpenAIResponse(responseBody); err == nil {
+				responseBody = translated
+				c.Header("Content-Type", "application/json")
+			}
+		}
 
 		// Write response body
 		c.Writer.Write(responseBody)
+		return usageData.PromptTokens + usageData.OutputTokens
 	}
 }
 
-func ensureToken(c *gin.Context) string {
-	authHeader := c.GetHeader("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Authorization token is required"})
+// recordError records a failed attempt. statusCode is 0 when the upstream
+// could not be reached.
+func (gr *gatewayRequest) recordError(target gatewayTarget, statusCode int) {
+	key := target.key
+	logic.RecordUsage(gr.appId, gr.appInfo.Name, key.ID, key.Name, gr.model, gr.path, 0, 0, 0, "error")
+	logic.ObserveGatewayRequest(logic.GatewayObservation{
+		App:        gr.appInfo.Name,
+		Key:        key.Name,
+		Model:      gr.model,
+		StatusCode: statusCode,
+		Duration:   time.Since(target.started),
+	})
+}
+
+// respondBudgetError rejects a request in the error format OpenAI clients
+// expect when a quota is exhausted.
+func respondBudgetError(c *gin.Context, err error) {
+	var budgetErr *logic.BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check budget"})
+		return
+	}
+	respondOpenAIError(c, http.StatusTooManyRequests, budgetErr.Error(), "insufficient_quota", "budget_exceeded")
+}
+
+// respondRateLimitError rejects a request that hit a rate limit
+func respondRateLimitError(c *gin.Context, err error) {
+	var rateErr *logic.RateLimitedError
+	if !errors.As(err, &rateErr) {
+		// The client went away while the request was queued
 		c.Abort()
-		return ""
+		return
+	}
+	retryAfter := int(math.Ceil(rateErr.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	respondOpenAIError(c, http.StatusTooManyRequests, rateErr.Error(), "rate_limit_error", "rate_limit_exceeded")
+}
+
+func respondOpenAIError(c *gin.Context, status int, message, errType, code string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    code,
+		},
+	})
+}
+
+// isCredentialHeader reports whether a client header carries the app token
+// and must not be forwarded upstream.
+func isCredentialHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "authorization", "x-api-key":
+		return true
+	}
+	return false
+}
+
+func ensureToken(c *gin.Context, protocol string) string {
+	authHeader := c.GetHeader("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	// Anthropic SDKs send the API key in a dedicated header
+	if protocol == logic.ProtocolAnthropic {
+		if apiKey := c.GetHeader("x-api-key"); apiKey != "" {
+			return apiKey
+		}
 	}
-	return strings.TrimPrefix(authHeader, "Bearer ")
+	c.JSON(http.StatusForbidden, gin.H{"error": "Authorization token is required"})
+	c.Abort()
+	return ""
 }
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"uni-token-service/logic"
+	"uni-token-service/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupKeysAPI sets up provider key endpoints
+func SetupKeysAPI(router gin.IRouter) {
+	api := router.Group("/keys").Use(RequireUserLogin())
+	{
+		api.GET("/protection", handleGetKeyProtection)
+		api.POST("/protection", handleSetKeyProtection)
+		api.POST("/:id/test", handleTestKey)
+	}
+}
+
+// handleGetKeyProtection reports how provider tokens are encrypted at rest
+func handleGetKeyProtection(c *gin.Context) {
+	vault, locked, err := store.GetKeyProtection()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get key protection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"mode":     vault.Mode,
+			"username": vault.Username,
+			"locked":   locked,
+		},
+	})
+}
+
+// handleSetKeyProtection wraps provider tokens with the local master key, or
+// with the caller's password. Password protection keeps the gateway locked
+// after a restart until the caller logs in again.
+func handleSetKeyProtection(c *gin.Context) {
+	var req struct {
+		Mode     string `json:"mode" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The password check counts towards the login throttle
+	username := c.GetString("username")
+	client := clientAddress(c)
+	if wait := logic.CheckLoginAllowed(username, client); wait > 0 {
+		recordAuthEvent(c, store.AuthEventLoginLocked, username, "key protection")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return
+	}
+
+	user, err := store.Users.Get(username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if ok, _ := logic.VerifyPassword(req.Password, user.Password); !ok {
+		logic.RecordLoginFailure(username, client)
+		recordAuthEvent(c, store.AuthEventLoginFailure, username, "key protection")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid password"})
+		return
+	}
+	logic.RecordLoginSuccess(username, client)
+
+	// Only the current owner may move keys away from their password
+	vault, _, err := store.GetKeyProtection()
+	if err == nil && vault.Mode == store.KeyProtectionPassword && vault.Username != user.Username {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Provider keys are protected by another user's password"})
+		return
+	}
+
+	if err := store.SetKeyProtection(req.Mode, user.Username, req.Password); err != nil {
+		if errors.Is(err, store.ErrKeysLocked) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAuthEvent(c, store.AuthEventKeyProtection, user.Username, req.Mode)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// handleTestKey checks that a key works and caches the models it can use
+func handleTestKey(c *gin.Context) {
+	if _, err := store.LLMKeys.Get(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+
+	result, err := logic.TestKey(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save model list"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
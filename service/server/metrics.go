@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net/http"
+
+	"uni-token-service/logic"
+	"uni-token-service/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// SetupMetricsAPI sets up the Prometheus endpoint. It answers 404 unless
+// metrics are enabled in the settings.
+func SetupMetricsAPI(router gin.IRouter) {
+	handler := promhttp.HandlerFor(logic.MetricsRegistry, promhttp.HandlerOpts{})
+	router.GET("/metrics", func(c *gin.Context) {
+		settings, err := store.GetSettings()
+		if err != nil || !settings.MetricsEnabled {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Metrics are disabled"})
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"uni-token-service/logic"
+	"uni-token-service/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupPricingAPI sets up model pricing catalogue endpoints
+func SetupPricingAPI(router gin.IRouter) {
+	api := router.Group("/pricing").Use(RequireUserLogin())
+	{
+		api.GET("/list", handleGetPricingList)
+		api.POST("/set", handleSetPricing)
+		api.POST("/delete", handleDeletePricing)
+		api.POST("/import", handleImportPricing)
+	}
+}
+
+// handleGetPricingList returns every pricing catalogue entry
+func handleGetPricingList(c *gin.Context) {
+	prices, err := store.Pricing.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pricing list"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    prices,
+	})
+}
+
+// handleSetPricing creates or updates a pricing entry
+func handleSetPricing(c *gin.Context) {
+	var req store.ModelPrice
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	price, err := logic.SaveModelPrice(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    price,
+	})
+}
+
+// handleDeletePricing removes a pricing entry
+func handleDeletePricing(c *gin.Context) {
+	var req struct {
+		ID string `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := store.Pricing.Delete(req.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pricing entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// handleImportPricing imports a JSON pricing file sent as the request body.
+// Pass replace=true to discard the current catalogue first.
+func handleImportPricing(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read pricing file"})
+		return
+	}
+
+	count, err := logic.ImportPricing(body, c.Query("replace") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"imported": count},
+	})
+}
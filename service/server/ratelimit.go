@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net/http"
+
+	"uni-token-service/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupRateLimitAPI sets up rate limiter inspection endpoints
+func SetupRateLimitAPI(router gin.IRouter) {
+	api := router.Group("/ratelimit").Use(RequireUserLogin())
+	{
+		api.GET("/state", handleGetRateLimitState)
+	}
+}
+
+// handleGetRateLimitState returns the current state of every app and key limiter
+func handleGetRateLimitState(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    logic.GetRateLimitStates(),
+	})
+}
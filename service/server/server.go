@@ -1,22 +1,54 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"sync"
 	"time"
+	"uni-token-service/constants"
+	"uni-token-service/discovery"
 	"uni-token-service/logic"
+	peerCred "uni-token-service/logic/peer_cred"
+	"uni-token-service/store"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func SetupAPIServer() (int, error) {
+// shutdownCtx is cancelled when a forced shutdown cuts off running requests
+var shutdownCtx, cancelUpstream = context.WithCancel(context.Background())
+
+// upstreamContext derives the context of requests to providers from the
+// client's request. It ends when the client goes away, or at a forced
+// shutdown, so streams do not keep blocking on the upstream.
+func upstreamContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := context.AfterFunc(shutdownCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// inFlight counts running requests, so shutdown can wait for their usage to
+// be recorded before the store is closed.
+var inFlight sync.WaitGroup
+
+func SetupAPIServer() (*http.Server, int, error) {
 	logic.InitJWTSecret()
 
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
+	router.Use(trackInFlight())
+	router.Use(RequireAllowedClient())
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:  []string{"http://localhost:*", "https://uni-token.app"},
@@ -38,11 +70,69 @@ func SetupAPIServer() (int, error) {
 
 	setupRoutes(router)
 
-	port := findAvailablePort()
+	// Listen on loopback only unless a bind address is configured
+	settings, err := store.GetSettings()
+	if err != nil {
+		return nil, 0, err
+	}
+	addresses := logic.UsableAddresses(logic.ListenAddresses(settings))
+	port := findAvailablePort(addresses)
 	logic.ServerPort = port
 
-	go router.Run(":" + strconv.Itoa(port))
-	return port, nil
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		listener, err := net.Listen(logic.ListenNetwork(address), net.JoinHostPort(address, strconv.Itoa(port)))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, 0, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// The socket is a per-user transport for local clients, so a failure to
+	// create it is not fatal
+	if listener, err := listenUnixSocket(discovery.GetServiceSocketPath()); err != nil {
+		log.Printf("Failed to listen on Unix socket: %v", err)
+	} else {
+		logic.ServerSocket = listener.Addr().String()
+		listeners = append(listeners, listener)
+	}
+
+	server := &http.Server{
+		Handler:     router,
+		ConnContext: peerCred.ConnContext,
+	}
+	for _, listener := range listeners {
+		log.Printf("Listening on %s", listener.Addr())
+		go server.Serve(listener)
+	}
+	return server, port, nil
+}
+
+// Shutdown stops accepting requests and waits for running ones, streams
+// included, to finish. Requests still running at the deadline are cut off,
+// and Shutdown returns once they have recorded their usage.
+func Shutdown(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		cancelUpstream()
+		server.Close()
+	}
+	inFlight.Wait()
+	return err
+}
+
+func trackInFlight() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inFlight.Add(1)
+		defer inFlight.Done()
+		c.Next()
+	}
 }
 
 func setupRoutes(router *gin.Engine) {
@@ -53,21 +143,92 @@ func setupRoutes(router *gin.Engine) {
 	SetupAuthAPI(router)
 	SetupProxyAPI(router)
 	SetupStoreAPI(router)
+	SetupPricingAPI(router)
+	SetupRateLimitAPI(router)
+	SetupSettingsAPI(router)
+	SetupMetricsAPI(router)
+	SetupKeysAPI(router)
+	SetupStatusAPI(router)
 }
 
-func isPortAvailable(port int) bool {
-	_, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
-	return err != nil
+// RequireAllowedClient rejects clients outside loopback and the allowed CIDRs
+func RequireAllowedClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Use the socket address, forwarded headers can be forged
+		if isUnixSocketRequest(c.Request) || logic.IsClientAllowed(c.Request.RemoteAddr, nil) {
+			c.Next()
+			return
+		}
+		settings, err := store.GetSettings()
+		if err != nil || !logic.IsClientAllowed(c.Request.RemoteAddr, settings.AllowedCIDRs) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Client address is not allowed"})
+			return
+		}
+		c.Next()
+	}
 }
 
-const portRangeStart = 18760
+func isUnixSocketRequest(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
 
-func findAvailablePort() int {
-	for port := portRangeStart; port < portRangeStart+10; port++ {
-		if isPortAvailable(port) {
+// listenUnixSocket listens on a socket only the current user can connect to
+func listenUnixSocket(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Remove a socket left behind by a previous run
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := listenPrivateSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	// A service running as root hands the socket to the user it serves
+	if constants.ShouldChangeUser && runtime.GOOS != "windows" {
+		if err := chownToServiceUser(path); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+func chownToServiceUser(path string) error {
+	serviceUser, err := user.Lookup(constants.UserName)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(serviceUser.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(serviceUser.Gid)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+func findAvailablePort(addresses []string) int {
+	for port := logic.PortRangeStart; port < logic.PortRangeEnd; port++ {
+		available := true
+		for _, address := range addresses {
+			if !logic.IsPortAvailable(address, port) {
+				available = false
+				break
+			}
+		}
+		if available {
 			return port
 		}
 		log.Printf("Port %d is not available", port)
 	}
-	panic(fmt.Sprintf("No available port found in range %d-%d", portRangeStart, portRangeStart+10))
+	panic(fmt.Sprintf("No available port found in range %d-%d", logic.PortRangeStart, logic.PortRangeEnd))
 }
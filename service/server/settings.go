@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+
+	"uni-token-service/logic"
+	"uni-token-service/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupSettingsAPI sets up service settings endpoints
+func SetupSettingsAPI(router gin.IRouter) {
+	api := router.Group("/settings").Use(RequireUserLogin())
+	{
+		api.GET("/get", handleGetSettings)
+		api.POST("/set", handleSetSettings)
+	}
+}
+
+// handleGetSettings returns the service settings
+func handleGetSettings(c *gin.Context) {
+	settings, err := store.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// handleSetSettings replaces the service settings. Listen address changes
+// take effect after a restart.
+func handleSetSettings(c *gin.Context) {
+	var req store.Settings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := logic.SaveSettings(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    req,
+	})
+}
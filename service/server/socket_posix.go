@@ -0,0 +1,18 @@
+//go:build !windows
+
+package server
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenPrivateSocket creates the socket file with mode 0600 from the start,
+// so no other user can connect before it is locked down. The umask is process
+// wide, but this runs during startup before any other file is created.
+func listenPrivateSocket(path string) (net.Listener, error) {
+	old := unix.Umask(0177)
+	defer unix.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package server
+
+import "net"
+
+// listenPrivateSocket listens on a socket. Windows does not apply file modes
+// to Unix sockets, access follows the ACL of the service directory.
+func listenPrivateSocket(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
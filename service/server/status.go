@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"uni-token-service/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupStatusAPI reports the service status to `service status`, which reaches
+// it over the Unix socket, and to logged-in users
+func SetupStatusAPI(router gin.IRouter) {
+	router.GET("/status", requireSocketOrLogin(), func(c *gin.Context) {
+		status, err := logic.CollectStatus()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to collect status"})
+			return
+		}
+		c.JSON(http.StatusOK, status)
+	})
+}
+
+// requireSocketOrLogin lets requests over the Unix socket through, since only
+// the service user can connect to it, and asks others to log in
+func requireSocketOrLogin() gin.HandlerFunc {
+	login := RequireUserLogin()
+	return func(c *gin.Context) {
+		if isUnixSocketRequest(c.Request) {
+			c.Next()
+			return
+		}
+		login(c)
+	}
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"io"
+	"slices"
+	"uni-token-service/logic"
 	"uni-token-service/store"
 
 	"github.com/gin-gonic/gin"
@@ -9,7 +11,7 @@ import (
 )
 
 func SetupStoreAPI(router gin.IRouter) {
-	api := router.Group("/store").Use(RequireUserLogin())
+	api := router.Group("/store").Use(RequireUserLogin(), rejectInternalBuckets())
 	{
 		api.GET("/:name", handleStoreGetAll)
 		api.DELETE("/:name", handleStoreDeleteAll)
@@ -19,6 +21,31 @@ func SetupStoreAPI(router gin.IRouter) {
 	}
 }
 
+func rejectInternalBuckets() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(store.InternalBuckets, c.Param("name")) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Bucket is not accessible"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// encodeStoreValue keeps provider tokens encrypted in the database
+func encodeStoreValue(name, key string, value []byte) ([]byte, error) {
+	if name == "llm_keys" {
+		return store.SealLLMKeyJSON(key, value)
+	}
+	return value, nil
+}
+
+func decodeStoreValue(name string, value []byte) ([]byte, error) {
+	if name == "llm_keys" {
+		return store.OpenLLMKeyJSON(value)
+	}
+	return value, nil
+}
+
 var createdBuckets = map[string]bool{}
 
 func ensureBucket(name string) error {
@@ -43,6 +70,10 @@ func handleStoreGetAll(c *gin.Context) {
 	err = store.Db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(name))
 		return b.ForEach(func(k, v []byte) error {
+			v, err := decodeStoreValue(name, v)
+			if err != nil {
+				return err
+			}
 			result[string(k)] = string(v)
 			return nil
 		})
@@ -79,6 +110,10 @@ func handleStoreGet(c *gin.Context) {
 			c.JSON(200, nil)
 			return nil
 		}
+		v, err := decodeStoreValue(name, v)
+		if err != nil {
+			return err
+		}
 		c.Data(200, "application/json", v)
 		return nil
 	})
@@ -101,6 +136,11 @@ func handleStorePut(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	body, err = encodeStoreValue(name, key, body)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 	err = store.Db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(name))
 		return b.Put([]byte(key), body)
@@ -109,6 +149,12 @@ func handleStorePut(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	if name == "llm_keys" {
+		// Cache the model list of new or repointed keys
+		if saved, err := store.LLMKeys.Get(key); err == nil {
+			logic.CheckKeyInBackground(saved)
+		}
+	}
 	c.Status(200)
 }
 
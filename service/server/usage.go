@@ -1,8 +1,11 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
+	"uni-token-service/logic"
 	"uni-token-service/store"
 
 	"github.com/gin-gonic/gin"
@@ -14,11 +17,14 @@ func SetupUsageAPI(router gin.IRouter) {
 	{
 		api.GET("/stats", handleGetUsageStats)
 		api.GET("/list", handleGetUsageList)
+		api.GET("/export", handleExportUsage)
 		api.POST("/clear", handleClearUsageRecords)
 	}
 }
 
-// handleGetUsageStats returns aggregated usage statistics
+// handleGetUsageStats returns aggregated usage statistics and a time series.
+// The range is given either as the last `days` days or as `from`/`to` RFC 3339
+// timestamps, and `interval` ("hour" or "day") sets the series resolution.
 func handleGetUsageStats(c *gin.Context) {
 	daysParam := c.DefaultQuery("days", "30")
 	days, err := strconv.Atoi(daysParam)
@@ -26,71 +32,180 @@ func handleGetUsageStats(c *gin.Context) {
 		days = 30
 	}
 
-	stats, err := store.GetUsageStats(days)
+	to := time.Now()
+	from := to.AddDate(0, 0, -days)
+	if fromParam := c.Query("from"); fromParam != "" {
+		if from, err = time.Parse(time.RFC3339, fromParam); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time"})
+			return
+		}
+	}
+	if toParam := c.Query("to"); toParam != "" {
+		if to, err = time.Parse(time.RFC3339, toParam); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time"})
+			return
+		}
+	}
+
+	// Hourly points for short ranges, daily points otherwise
+	interval := c.Query("interval")
+	if interval == "" {
+		interval = store.RollupDaily
+		if to.Sub(from) <= 48*time.Hour {
+			interval = store.RollupHourly
+		}
+	}
+
+	stats, err := store.GetUsageStats(from, to, interval)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage stats"})
 		return
 	}
 
+	stats.Budgets, err = store.GetBudgetStates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get budget states"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    stats,
 	})
 }
 
-// handleGetUsageList returns paginated usage records
+// parseUsageFilter reads usage filters from the query string. Times are RFC 3339.
+func parseUsageFilter(c *gin.Context) (store.UsageFilter, error) {
+	filter := store.UsageFilter{
+		AppID:    c.Query("appId"),
+		Key:      c.Query("key"),
+		Model:    c.Query("model"),
+		Status:   c.Query("status"),
+		Endpoint: c.Query("endpoint"),
+	}
+
+	var err error
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid from time: %w", err)
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid to time: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// handleGetUsageList returns filtered usage records, most recent first, paged
+// by nextCursor. Requests with `page` get the page-based listing instead.
 func handleGetUsageList(c *gin.Context) {
-	usages, err := store.Usage.List()
+	filter, err := parseUsageFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage list"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Simple pagination
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if c.Query("page") != "" {
+		if c.Query("cursor") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page and cursor cannot be combined"})
+			return
+		}
+		handleGetUsagePage(c, filter)
+		return
+	}
 
-	if page < 1 {
-		page = 1
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", c.DefaultQuery("pageSize", "50")))
+	if limit < 1 || limit > 200 {
+		limit = 50
 	}
-	if pageSize < 1 || pageSize > 200 {
-		pageSize = 50
+
+	records, nextCursor, err := store.QueryUsage(filter, c.Query("cursor"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage list"})
+		return
 	}
 
-	start := (page - 1) * pageSize
-	end := start + pageSize
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"records":    records,
+			"nextCursor": nextCursor,
+			"hasMore":    nextCursor != "",
+			"limit":      limit,
+		},
+	})
+}
 
-	if start >= len(usages) {
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"data": gin.H{
-				"records":  []interface{}{},
-				"total":    len(usages),
-				"page":     page,
-				"pageSize": pageSize,
-			},
-		})
+// handleGetUsagePage serves the page-based usage list of earlier versions
+func handleGetUsagePage(c *gin.Context, filter store.UsageFilter) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
 		return
 	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
 
-	if end > len(usages) {
-		end = len(usages)
+	records, total, err := store.QueryUsagePage(filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage list"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"records":  usages[start:end],
-			"total":    len(usages),
+			"records":  records,
+			"total":    total,
 			"page":     page,
 			"pageSize": pageSize,
 		},
 	})
 }
 
+// handleExportUsage streams filtered usage records, oldest first, as CSV or
+// JSON Lines. With `groupBy=day` the records are aggregated per day.
+func handleExportUsage(c *gin.Context) {
+	filter, err := parseUsageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", logic.ExportFormatCSV)
+	if err := logic.ValidateExportFormat(format); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupBy := c.Query("groupBy")
+	if groupBy != "" && groupBy != store.RollupDaily {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be empty or \"day\""})
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == logic.ExportFormatJSONL {
+		contentType = "application/x-ndjson"
+	}
+	filename := fmt.Sprintf("usage-%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure can only cut the stream short
+	if err := logic.ExportUsage(c.Writer, filter, format, groupBy == store.RollupDaily); err != nil {
+		c.Error(err)
+	}
+}
+
 // handleClearUsageRecords clears all usage records
 func handleClearUsageRecords(c *gin.Context) {
-	err := store.Usage.Clear()
+	err := store.ClearUsage()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear usage records"})
 		return
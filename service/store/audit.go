@@ -0,0 +1,98 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const authAuditRetention = 90 * 24 * time.Hour
+
+const (
+	AuthEventLoginSuccess   = "login_success"
+	AuthEventLoginFailure   = "login_failure"
+	AuthEventLoginLocked    = "login_locked"
+	AuthEventRegister       = "register"
+	AuthEventRegisterDenied = "register_denied"
+	AuthEventLogout         = "logout"
+	AuthEventRotateSecret   = "rotate_secret"
+	AuthEventPasswordChange = "password_change"
+	AuthEventKeyProtection  = "key_protection"
+)
+
+// AuthEvent is an entry of the authentication audit trail
+type AuthEvent struct {
+	ID         string    `json:"id,omitempty"`
+	Type       string    `json:"type"`
+	Username   string    `json:"username,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// RecordAuthEvent appends an event to the audit trail
+func RecordAuthEvent(event AuthEvent) error {
+	event.Timestamp = time.Now()
+	return Db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(AuthAudit.bucketName))
+		// Step forward on the rare key collision, as usage records do
+		t := event.Timestamp
+		for b.Get([]byte(generateID(t))) != nil {
+			t = t.Add(time.Nanosecond)
+		}
+		event.ID = generateID(t)
+		v, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(event.ID), v)
+	})
+}
+
+// ListAuthEvents returns up to limit events, most recent first
+func ListAuthEvents(limit int) ([]AuthEvent, error) {
+	events := make([]AuthEvent, 0)
+	err := Db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(AuthAudit.bucketName)).Cursor()
+		for k, v := c.Last(); k != nil && len(events) < limit; k, v = c.Prev() {
+			var event AuthEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// pruneAuthEvents drops audit events past the retention period
+func pruneAuthEvents() error {
+	cutoff := []byte(time.Now().Add(-authAuditRetention).Local().Format(usageIDTimeFormat))
+	return Db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(AuthAudit.bucketName)).Cursor()
+		for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ensureAdmin makes the first user an admin in databases created before
+// users had roles
+func ensureAdmin() error {
+	users, err := Users.List()
+	if err != nil || len(users) == 0 {
+		return err
+	}
+	for _, user := range users {
+		if user.Admin {
+			return nil
+		}
+	}
+	users[0].Admin = true
+	return Users.Put(users[0].Username, users[0])
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// SigningKey is a key for signing UI session tokens
+type SigningKey struct {
+	ID        string     `json:"id"`
+	Secret    string     `json:"secret"` // Encrypted with the master key
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"` // Set once a newer key signs tokens
+}
+
+// RevokedToken is a session token rejected before it expires
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// IsTokenRevoked reports whether a session token ID is on the revocation list
+func IsTokenRevoked(id string) bool {
+	revoked := false
+	Db.View(func(tx *bbolt.Tx) error {
+		revoked = tx.Bucket([]byte(RevokedTokens.bucketName)).Get([]byte(id)) != nil
+		return nil
+	})
+	return revoked
+}
+
+// PruneRevokedTokens drops revoked tokens that have expired anyway
+func PruneRevokedTokens() error {
+	tokens, err := RevokedTokens.List()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, token := range tokens {
+		if token.ExpiresAt.Before(now) {
+			if err := RevokedTokens.Delete(token.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"time"
+)
+
+// Budget limits spending per calendar day and month. Zero means unlimited.
+type Budget struct {
+	DailyTokens   int     `json:"dailyTokens"`
+	MonthlyTokens int     `json:"monthlyTokens"`
+	DailyCost     float64 `json:"dailyCost"`
+	MonthlyCost   float64 `json:"monthlyCost"`
+}
+
+// IsEmpty reports whether the budget sets no limit at all
+func (b *Budget) IsEmpty() bool {
+	return b == nil || *b == Budget{}
+}
+
+// BudgetState reports spending against a budget in the current day and month
+type BudgetState struct {
+	Budget    Budget `json:"budget"`
+	Spent     Budget `json:"spent"`
+	Remaining Budget `json:"remaining"` // Only meaningful for limits that are set
+	Exceeded  string `json:"exceeded,omitempty"`
+}
+
+// GetAppBudgetState returns the budget state of an app, or nil if it has no budget
+func GetAppBudgetState(app AppInfo) (*BudgetState, error) {
+	return getBudgetState(app.Budget, func(rollup UsageRollup) bool {
+		return rollup.AppID == app.ID
+	})
+}
+
+// GetKeyBudgetState returns the budget state of a key, or nil if it has no budget
+func GetKeyBudgetState(key LLMKey) (*BudgetState, error) {
+	return getBudgetState(key.Budget, func(rollup UsageRollup) bool {
+		return rollup.KeyID == key.ID
+	})
+}
+
+func getBudgetState(budget *Budget, match func(UsageRollup) bool) (*BudgetState, error) {
+	if budget.IsEmpty() {
+		return nil, nil
+	}
+
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	state := &BudgetState{Budget: *budget}
+	err := ScanRollups(RollupDaily, monthStart, dayStart.AddDate(0, 0, 1), func(rollup UsageRollup) {
+		if !match(rollup) {
+			return
+		}
+		state.Spent.MonthlyTokens += rollup.TotalTokens
+		state.Spent.MonthlyCost += rollup.Cost
+		if !rollup.Period.Before(dayStart) {
+			state.Spent.DailyTokens += rollup.TotalTokens
+			state.Spent.DailyCost += rollup.Cost
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state.Remaining = Budget{
+		DailyTokens:   max(budget.DailyTokens-state.Spent.DailyTokens, 0),
+		MonthlyTokens: max(budget.MonthlyTokens-state.Spent.MonthlyTokens, 0),
+		DailyCost:     max(budget.DailyCost-state.Spent.DailyCost, 0),
+		MonthlyCost:   max(budget.MonthlyCost-state.Spent.MonthlyCost, 0),
+	}
+
+	switch {
+	case budget.DailyTokens > 0 && state.Spent.DailyTokens >= budget.DailyTokens:
+		state.Exceeded = "dailyTokens"
+	case budget.MonthlyTokens > 0 && state.Spent.MonthlyTokens >= budget.MonthlyTokens:
+		state.Exceeded = "monthlyTokens"
+	case budget.DailyCost > 0 && state.Spent.DailyCost >= budget.DailyCost:
+		state.Exceeded = "dailyCost"
+	case budget.MonthlyCost > 0 && state.Spent.MonthlyCost >= budget.MonthlyCost:
+		state.Exceeded = "monthlyCost"
+	}
+
+	return state, nil
+}
+
+// BudgetStates holds the budget state of every app and key that has a budget
+type BudgetStates struct {
+	Apps map[string]BudgetState `json:"apps"`
+	Keys map[string]BudgetState `json:"keys"`
+}
+
+// GetBudgetStates returns budget states keyed by app ID and key ID
+func GetBudgetStates() (*BudgetStates, error) {
+	states := &BudgetStates{
+		Apps: make(map[string]BudgetState),
+		Keys: make(map[string]BudgetState),
+	}
+
+	apps, err := Apps.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		state, err := GetAppBudgetState(app)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			states.Apps[app.ID] = *state
+		}
+	}
+
+	keys, err := LLMKeys.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		state, err := GetKeyBudgetState(key)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			states.Keys[key.ID] = *state
+		}
+	}
+
+	return states, nil
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/argon2"
+)
+
+// Provider tokens are sealed with a random data key. The data key itself is
+// wrapped by a key-encryption key: either the local master key, so the gateway
+// works unattended, or a key derived from the owner's password, in which case
+// tokens stay locked after a restart until the owner logs in.
+const (
+	KeyProtectionMaster   = "master"
+	KeyProtectionPassword = "password"
+)
+
+const keyVaultID = "default"
+
+type KeyVault struct {
+	Mode       string    `json:"mode"`
+	Username   string    `json:"username,omitempty"` // Whose password wraps the data key
+	Salt       string    `json:"salt,omitempty"`
+	WrappedKey string    `json:"wrappedKey"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+var ErrKeysLocked = errors.New("provider keys are locked until the owner logs in")
+
+var (
+	dataKey   []byte
+	dataKeyMu sync.RWMutex
+)
+
+func loadKeyVault() error {
+	vault, err := KeyVaults.Get(keyVaultID)
+	if err != nil {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		if err := saveKeyVault(key, KeyProtectionMaster, "", ""); err != nil {
+			return err
+		}
+		setDataKey(key)
+		return migrateLLMKeys()
+	}
+
+	if vault.Mode != KeyProtectionMaster {
+		// Wait for the owner to log in
+		return nil
+	}
+	key, err := decryptWithKey(masterKey, vault.WrappedKey)
+	if err != nil {
+		return err
+	}
+	setDataKey(key)
+	return migrateLLMKeys()
+}
+
+func saveKeyVault(key []byte, mode, username, password string) error {
+	vault, err := wrapKeyVault(key, mode, username, password)
+	if err != nil {
+		return err
+	}
+	return KeyVaults.Put(keyVaultID, vault)
+}
+
+// wrapKeyVault wraps the data key with the key-encryption key of a mode
+func wrapKeyVault(key []byte, mode, username, password string) (KeyVault, error) {
+	vault := KeyVault{
+		Mode:      mode,
+		UpdatedAt: time.Now(),
+	}
+	kek := masterKey
+	if mode == KeyProtectionPassword {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return vault, err
+		}
+		vault.Username = username
+		vault.Salt = base64.StdEncoding.EncodeToString(salt)
+		kek = passwordKEK(password, salt)
+	}
+
+	wrapped, err := encryptWithKey(kek, key)
+	if err != nil {
+		return vault, err
+	}
+	vault.WrappedKey = wrapped
+	return vault, nil
+}
+
+func passwordKEK(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, 3, 64*1024, 4, 32)
+}
+
+func unwrapWithPassword(vault KeyVault, password string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(vault.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return decryptWithKey(passwordKEK(password, salt), vault.WrappedKey)
+}
+
+func setDataKey(key []byte) {
+	dataKeyMu.Lock()
+	defer dataKeyMu.Unlock()
+	dataKey = key
+}
+
+func currentDataKey() ([]byte, error) {
+	dataKeyMu.RLock()
+	defer dataKeyMu.RUnlock()
+	if dataKey == nil {
+		return nil, ErrKeysLocked
+	}
+	return dataKey, nil
+}
+
+// GetKeyProtection reports how provider keys are protected and whether they
+// can currently be decrypted
+func GetKeyProtection() (vault KeyVault, locked bool, err error) {
+	vault, err = KeyVaults.Get(keyVaultID)
+	if err != nil {
+		return vault, true, err
+	}
+	_, err = currentDataKey()
+	vault.Salt = ""
+	vault.WrappedKey = ""
+	return vault, err != nil, nil
+}
+
+// UnlockKeyVault unwraps the data key with the owner's password after login.
+// Logins by other users, or while the vault is already unlocked, are ignored.
+func UnlockKeyVault(username, password string) error {
+	vault, err := KeyVaults.Get(keyVaultID)
+	if err != nil || vault.Mode != KeyProtectionPassword || vault.Username != username {
+		return nil
+	}
+	if _, err := currentDataKey(); err == nil {
+		return nil
+	}
+
+	key, err := unwrapWithPassword(vault, password)
+	if err != nil {
+		return err
+	}
+	setDataKey(key)
+	return migrateLLMKeys()
+}
+
+// SetKeyProtection wraps the data key with the master key, or with the given
+// user's password. The vault must be unlocked.
+func SetKeyProtection(mode, username, password string) error {
+	if mode != KeyProtectionMaster && mode != KeyProtectionPassword {
+		return errors.New("unknown key protection mode")
+	}
+	key, err := currentDataKey()
+	if err != nil {
+		return err
+	}
+	return saveKeyVault(key, mode, username, password)
+}
+
+// SaveUserPassword saves a user whose password changed. If the old password
+// protects the provider keys, the data key is re-wrapped with the new one in
+// the same transaction, so the user and the vault cannot get out of step. The
+// tokens themselves do not need to be re-encrypted.
+func SaveUserPassword(user UserInfo, oldPassword, newPassword string) error {
+	userData, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	var unlocked []byte
+	err = Db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(Users.bucketName)).Put([]byte(user.Username), userData); err != nil {
+			return err
+		}
+
+		vaults := tx.Bucket([]byte(KeyVaults.bucketName))
+		var vault KeyVault
+		err := json.Unmarshal(vaults.Get([]byte(keyVaultID)), &vault)
+		if err != nil || vault.Mode != KeyProtectionPassword || vault.Username != user.Username {
+			return nil
+		}
+		key, err := currentDataKey()
+		if err != nil {
+			if key, err = unwrapWithPassword(vault, oldPassword); err != nil {
+				return err
+			}
+			unlocked = key
+		}
+		if vault, err = wrapKeyVault(key, KeyProtectionPassword, user.Username, newPassword); err != nil {
+			return err
+		}
+		vaultData, err := json.Marshal(vault)
+		if err != nil {
+			return err
+		}
+		return vaults.Put([]byte(keyVaultID), vaultData)
+	})
+	if err == nil && unlocked != nil {
+		setDataKey(unlocked)
+	}
+	return err
+}
+
+// SealLLMKey moves a plaintext token into its encrypted field
+func SealLLMKey(key *LLMKey) error {
+	if key.Token == "" {
+		return nil
+	}
+	dk, err := currentDataKey()
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptWithKey(dk, []byte(key.Token))
+	if err != nil {
+		return err
+	}
+	key.EncryptedToken = sealed
+	key.Token = ""
+	return nil
+}
+
+// OpenLLMKey returns the plaintext token of a key
+func OpenLLMKey(key LLMKey) (string, error) {
+	if key.EncryptedToken == "" {
+		return key.Token, nil
+	}
+	dk, err := currentDataKey()
+	if err != nil {
+		return "", err
+	}
+	token, err := decryptWithKey(dk, key.EncryptedToken)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// SealLLMKeyJSON encrypts a key submitted through the generic store API. An
+// empty token keeps the one already stored, so keys can be edited while the
+// vault is locked.
+func SealLLMKeyJSON(id string, raw []byte) ([]byte, error) {
+	var key LLMKey
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, err
+	}
+	key.EncryptedToken = ""
+	if existing, err := LLMKeys.Get(id); err == nil {
+		existingToken, _ := OpenLLMKey(existing)
+		if key.Token == "" {
+			key.EncryptedToken = existing.EncryptedToken
+		}
+		// Keep the cached model list unless the key now points elsewhere
+		sameTarget := key.BaseURL == existing.BaseURL && key.Protocol == existing.Protocol &&
+			(key.Token == "" || key.Token == existingToken)
+		if key.Models == nil && sameTarget {
+			key.Models = existing.Models
+			key.ModelsUpdatedAt = existing.ModelsUpdatedAt
+			key.ModelsCheckedAt = existing.ModelsCheckedAt
+		} else if !sameTarget {
+			key.ModelsCheckedAt = nil
+		}
+	}
+	if err := SealLLMKey(&key); err != nil {
+		return nil, err
+	}
+	return json.Marshal(key)
+}
+
+// OpenLLMKeyJSON decrypts a stored key for the generic store API. Tokens are
+// left empty while the vault is locked.
+func OpenLLMKeyJSON(raw []byte) ([]byte, error) {
+	var key LLMKey
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, err
+	}
+	token, err := OpenLLMKey(key)
+	if err != nil && !errors.Is(err, ErrKeysLocked) {
+		return nil, err
+	}
+	key.Token = token
+	key.EncryptedToken = ""
+	return json.Marshal(key)
+}
+
+// migrateLLMKeys encrypts tokens saved before encryption at rest existed
+func migrateLLMKeys() error {
+	return Db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(LLMKeys.bucketName))
+		updates := map[string][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			var key LLMKey
+			if err := json.Unmarshal(v, &key); err != nil || key.Token == "" {
+				return nil
+			}
+			if err := SealLLMKey(&key); err != nil {
+				return err
+			}
+			data, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = data
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,51 @@
+package store
+
+import "testing"
+
+func TestSaveUserPassword(t *testing.T) {
+	initTestStore(t)
+	if err := Users.Put("alice", UserInfo{Username: "alice", Password: "old-hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetKeyProtection(KeyProtectionPassword, "alice", "old"); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := currentDataKey()
+	setDataKey(nil) // as after a restart, before alice logs in
+
+	// A failed re-key must not save the user either
+	err := SaveUserPassword(UserInfo{Username: "alice", Password: "new-hash"}, "wrong", "new")
+	if err == nil {
+		t.Fatal("expected the wrong old password to fail")
+	}
+	if user, _ := Users.Get("alice"); user.Password != "old-hash" {
+		t.Errorf("user was saved without re-keying the vault")
+	}
+
+	if err := SaveUserPassword(UserInfo{Username: "alice", Password: "new-hash"}, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := Users.Get("alice"); user.Password != "new-hash" {
+		t.Errorf("user was not saved")
+	}
+	vault, _ := KeyVaults.Get(keyVaultID)
+	unwrapped, err := unwrapWithPassword(vault, "new")
+	if err != nil || string(unwrapped) != string(key) {
+		t.Errorf("vault is not wrapped with the new password: %v", err)
+	}
+}
+
+func TestSaveUserPasswordOtherUser(t *testing.T) {
+	initTestStore(t)
+	if err := SetKeyProtection(KeyProtectionPassword, "alice", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := KeyVaults.Get(keyVaultID)
+
+	if err := SaveUserPassword(UserInfo{Username: "bob", Password: "hash"}, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := KeyVaults.Get(keyVaultID); after.WrappedKey != before.WrappedKey {
+		t.Error("another user's password change re-keyed the vault")
+	}
+}
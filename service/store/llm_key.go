@@ -0,0 +1,46 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// SaveKeyCheck records a test of the key and, if it succeeded, caches the
+// model list it returned. Failed tests only record the time, so broken keys
+// are not tested over and over. The key is re-read in the same transaction, so
+// edits made during the test are kept, and nothing is saved if the key now
+// points at another provider or token. It reports whether the test was saved.
+func SaveKeyCheck(tested LLMKey, ok bool, models []string, at time.Time) (bool, error) {
+	saved := false
+	err := Db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(LLMKeys.bucketName))
+		data := b.Get([]byte(tested.ID))
+		if data == nil {
+			return errors.New("key not found")
+		}
+		var key LLMKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return err
+		}
+		if key.BaseURL != tested.BaseURL || key.Protocol != tested.Protocol ||
+			key.EncryptedToken != tested.EncryptedToken || key.Token != tested.Token {
+			return nil
+		}
+
+		key.ModelsCheckedAt = &at
+		if ok {
+			key.Models = models
+			key.ModelsUpdatedAt = &at
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		saved = true
+		return b.Put([]byte(key.ID), data)
+	})
+	return saved, err
+}
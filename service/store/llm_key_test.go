@@ -0,0 +1,64 @@
+package store
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSaveKeyCheck(t *testing.T) {
+	initTestStore(t)
+	tested := LLMKey{ID: "k", Name: "old name", BaseURL: "https://a.example.com", EncryptedToken: "sealed"}
+
+	tests := []struct {
+		name      string
+		edit      func(key *LLMKey)
+		wantSaved bool
+	}{
+		{"unchanged", func(key *LLMKey) {}, true},
+		{"renamed during the test", func(key *LLMKey) { key.Name = "new name" }, true},
+		{"base URL changed", func(key *LLMKey) { key.BaseURL = "https://b.example.com" }, false},
+		{"protocol changed", func(key *LLMKey) { key.Protocol = "anthropic" }, false},
+		{"token changed", func(key *LLMKey) { key.EncryptedToken = "resealed" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := tested
+			tt.edit(&current)
+			if err := LLMKeys.Put(current.ID, current); err != nil {
+				t.Fatal(err)
+			}
+
+			saved, err := SaveKeyCheck(tested, true, []string{"m1"}, time.Now())
+			if err != nil || saved != tt.wantSaved {
+				t.Fatalf("SaveKeyCheck() = %v, %v, want %v", saved, err, tt.wantSaved)
+			}
+			stored, _ := LLMKeys.Get(current.ID)
+			if stored.Name != current.Name || stored.BaseURL != current.BaseURL {
+				t.Errorf("an edit made during the test was lost: %+v", stored)
+			}
+			if got := slices.Equal(stored.Models, []string{"m1"}); got != tt.wantSaved {
+				t.Errorf("models = %v, saved %v", stored.Models, tt.wantSaved)
+			}
+		})
+	}
+
+	if _, err := SaveKeyCheck(LLMKey{ID: "gone"}, true, nil, time.Now()); err == nil {
+		t.Error("saved models of a deleted key")
+	}
+
+	// A failed test keeps the last model list
+	if err := LLMKeys.Put(tested.ID, tested); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveKeyCheck(tested, true, []string{"m1"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveKeyCheck(tested, false, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := LLMKeys.Get(tested.ID)
+	if stored.ModelsCheckedAt == nil || !slices.Equal(stored.Models, []string{"m1"}) {
+		t.Errorf("failed test not recorded as expected: %+v", stored)
+	}
+}
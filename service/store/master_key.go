@@ -0,0 +1,95 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The master key lives next to the database in a file only the service user
+// can read. It keeps secrets out of the database file itself, e.g. when the
+// database is copied or backed up.
+var masterKey []byte
+
+func masterKeyPath(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "master.key")
+}
+
+func loadMasterKey(dbPath string) {
+	path := masterKeyPath(dbPath)
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) == 32 {
+		masterKey = key
+		return
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal("Failed to read master key:", err)
+	}
+	if err == nil {
+		log.Fatal("Master key file is corrupted: ", path)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal("Failed to generate master key:", err)
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		log.Fatal("Failed to write master key:", err)
+	}
+	masterKey = key
+}
+
+// EncryptWithMasterKey seals data with AES-GCM and returns it base64 encoded
+func EncryptWithMasterKey(plaintext []byte) (string, error) {
+	return encryptWithKey(masterKey, plaintext)
+}
+
+// DecryptWithMasterKey opens data sealed by EncryptWithMasterKey
+func DecryptWithMasterKey(ciphertext string) ([]byte, error) {
+	return decryptWithKey(masterKey, ciphertext)
+}
+
+func encryptWithKey(key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptWithKey(key []byte, ciphertext string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, data := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, errors.New("encryption key is not loaded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,16 @@
+package store
+
+// ModelPrice is a pricing catalogue entry. Rates are in USD per 1K tokens.
+type ModelPrice struct {
+	ID string `json:"id"`
+	// Provider matches a key's type (e.g. "siliconflow") or base URL. It may
+	// contain * and ? wildcards. Empty matches every provider.
+	Provider string `json:"provider"`
+	// Model is a glob pattern matched against the model name, e.g. "gpt-4o-mini*"
+	Model            string  `json:"model"`
+	PromptRate       float64 `json:"promptRate"`
+	OutputRate       float64 `json:"outputRate"`
+	CachedPromptRate float64 `json:"cachedPromptRate,omitempty"` // Defaults to PromptRate
+	ReasoningRate    float64 `json:"reasoningRate,omitempty"`    // Defaults to OutputRate
+	ImageRate        float64 `json:"imageRate,omitempty"`        // USD per generated image
+}
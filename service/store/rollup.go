@@ -0,0 +1,185 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	RollupHourly = "hour"
+	RollupDaily  = "day"
+)
+
+var rollupPeriodFormats = map[string]string{
+	RollupHourly: "2006010215",
+	RollupDaily:  "20060102",
+}
+
+// UsageRollup aggregates the usage of one app, key and model over an hour or a day
+type UsageRollup struct {
+	Period       time.Time `json:"period"`
+	AppID        string    `json:"appId"`
+	AppName      string    `json:"appName"`
+	KeyID        string    `json:"keyId"`
+	Key          string    `json:"key"`
+	Model        string    `json:"model"`
+	PromptTokens int       `json:"promptTokens"`
+	OutputTokens int       `json:"outputTokens"`
+	TotalTokens  int       `json:"totalTokens"`
+	Cost         float64   `json:"cost"`
+	Requests     int       `json:"requests"`
+	Errors       int       `json:"errors"`
+}
+
+func truncatePeriod(t time.Time, granularity string) time.Time {
+	if granularity == RollupDaily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// nextPeriod returns the start of the period following the one containing t
+func nextPeriod(t time.Time, granularity string) time.Time {
+	t = truncatePeriod(t, granularity)
+	if granularity == RollupDaily {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Hour)
+}
+
+// Rollup keys sort by granularity, then period, so a time range is one cursor scan
+func rollupPrefix(granularity string, period time.Time) string {
+	return granularity + "|" + period.Format(rollupPeriodFormats[granularity]) + "|"
+}
+
+func rollupKey(granularity string, usage TokenUsage) []byte {
+	period := truncatePeriod(usage.Timestamp.Local(), granularity)
+	return []byte(rollupPrefix(granularity, period) +
+		strings.Join([]string{usage.AppID, usage.KeyID, usage.Key, usage.Model}, "|"))
+}
+
+// addToRollups adds a usage record to its hourly and daily rollups
+func addToRollups(tx *bbolt.Tx, usage TokenUsage) error {
+	b := tx.Bucket([]byte(UsageRollups.bucketName))
+	for granularity := range rollupPeriodFormats {
+		key := rollupKey(granularity, usage)
+
+		rollup := UsageRollup{
+			Period:  truncatePeriod(usage.Timestamp.Local(), granularity),
+			AppID:   usage.AppID,
+			AppName: usage.AppName,
+			KeyID:   usage.KeyID,
+			Key:     usage.Key,
+			Model:   usage.Model,
+		}
+		if v := b.Get(key); v != nil {
+			if err := json.Unmarshal(v, &rollup); err != nil {
+				return err
+			}
+		}
+
+		rollup.PromptTokens += usage.PromptTokens
+		rollup.OutputTokens += usage.OutputTokens
+		rollup.TotalTokens += usage.TotalTokens
+		rollup.Cost += usage.Cost
+		rollup.Requests++
+		if usage.Status == "error" {
+			rollup.Errors++
+		}
+		// Keep the latest display name
+		if usage.AppName != "" {
+			rollup.AppName = usage.AppName
+		}
+
+		v, err := json.Marshal(rollup)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanRollups visits rollups of a granularity whose period starts within [from, to)
+func ScanRollups(granularity string, from, to time.Time, visit func(UsageRollup)) error {
+	return Db.View(func(tx *bbolt.Tx) error {
+		return scanRollups(tx, granularity, from, to, visit)
+	})
+}
+
+func scanRollups(tx *bbolt.Tx, granularity string, from, to time.Time, visit func(UsageRollup)) error {
+	if !from.Before(to) {
+		return nil
+	}
+	cursor := tx.Bucket([]byte(UsageRollups.bucketName)).Cursor()
+	end := rollupPrefix(granularity, to.Local())
+	for k, v := cursor.Seek([]byte(rollupPrefix(granularity, from.Local()))); k != nil && string(k) < end; k, v = cursor.Next() {
+		if !strings.HasPrefix(string(k), granularity+"|") {
+			break
+		}
+		var rollup UsageRollup
+		if err := json.Unmarshal(v, &rollup); err != nil {
+			return err
+		}
+		visit(rollup)
+	}
+	return nil
+}
+
+// ScanRollupRange visits the rollups covering [from, to), widened to whole
+// hours. Whole days use daily rollups and the partial days at either end use
+// hourly ones, so long ranges stay cheap.
+func ScanRollupRange(from, to time.Time, visit func(UsageRollup)) error {
+	from = truncatePeriod(from.Local(), RollupHourly)
+	to = nextPeriod(to.Local().Add(-time.Nanosecond), RollupHourly)
+	if !from.Before(to) {
+		return nil
+	}
+
+	return Db.View(func(tx *bbolt.Tx) error {
+		firstDay := truncatePeriod(from, RollupDaily)
+		if firstDay.Before(from) {
+			firstDay = firstDay.AddDate(0, 0, 1)
+		}
+		lastDay := truncatePeriod(to, RollupDaily)
+
+		if !firstDay.Before(lastDay) {
+			// No whole day in range
+			return scanRollups(tx, RollupHourly, from, to, visit)
+		}
+		if err := scanRollups(tx, RollupHourly, from, firstDay, visit); err != nil {
+			return err
+		}
+		if err := scanRollups(tx, RollupDaily, firstDay, lastDay, visit); err != nil {
+			return err
+		}
+		return scanRollups(tx, RollupHourly, lastDay, to, visit)
+	})
+}
+
+// rebuildRollupsIfMissing fills the rollups from raw records for databases
+// created before rollups existed.
+func rebuildRollupsIfMissing() {
+	err := Db.Update(func(tx *bbolt.Tx) error {
+		rollups := tx.Bucket([]byte(UsageRollups.bucketName))
+		if k, _ := rollups.Cursor().First(); k != nil {
+			return nil
+		}
+		return tx.Bucket([]byte(Usage.bucketName)).ForEach(func(k, v []byte) error {
+			var usage TokenUsage
+			if err := json.Unmarshal(v, &usage); err != nil {
+				return err
+			}
+			return addToRollups(tx, usage)
+		})
+	})
+	if err != nil {
+		log.Println("Failed to rebuild usage rollups:", err)
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func initTestStore(t *testing.T) {
+	t.Helper()
+	Init(filepath.Join(t.TempDir(), "data.db"))
+	t.Cleanup(func() { Close() })
+}
+
+func addTestUsage(t *testing.T, at time.Time) {
+	t.Helper()
+	err := Db.Update(func(tx *bbolt.Tx) error {
+		return addToRollups(tx, TokenUsage{
+			AppID:        "app",
+			KeyID:        "key",
+			Key:          "name",
+			Model:        "model",
+			PromptTokens: 1,
+			TotalTokens:  1,
+			Status:       "success",
+			Timestamp:    at,
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollupKey(t *testing.T) {
+	usage := TokenUsage{
+		AppID:     "app",
+		KeyID:     "key-id",
+		Key:       "key",
+		Model:     "gpt-4o",
+		Timestamp: time.Date(2026, 3, 7, 9, 45, 12, 0, time.Local),
+	}
+	tests := map[string]string{
+		RollupHourly: "hour|2026030709|app|key-id|key|gpt-4o",
+		RollupDaily:  "day|20260307|app|key-id|key|gpt-4o",
+	}
+	for granularity, want := range tests {
+		if got := string(rollupKey(granularity, usage)); got != want {
+			t.Errorf("rollupKey(%s) = %q, want %q", granularity, got, want)
+		}
+	}
+
+	// Keys of one granularity must sort by period
+	earlier := usage
+	earlier.Timestamp = time.Date(2025, 12, 31, 23, 0, 0, 0, time.Local)
+	earlier.AppID = "zzz"
+	if string(rollupKey(RollupHourly, earlier)) >= string(rollupKey(RollupHourly, usage)) {
+		t.Error("rollup keys do not sort by period")
+	}
+}
+
+func TestScanRollupRange(t *testing.T) {
+	initTestStore(t)
+	day := time.Date(2026, 3, 7, 0, 0, 0, 0, time.Local)
+	for _, at := range []time.Time{
+		day.Add(-30 * time.Minute),              // previous day, 23:30
+		day.Add(10 * time.Minute),               // 00:10
+		day.Add(12 * time.Hour),                 // 12:00
+		day.AddDate(0, 0, 1).Add(5 * time.Hour), // next day, 05:00
+		day.AddDate(0, 0, 2).Add(1 * time.Hour), // two days later, 01:00
+	} {
+		addTestUsage(t, at)
+	}
+
+	tests := []struct {
+		name         string
+		from, to     time.Time
+		wantRequests int
+		wantVisits   int
+	}{
+		{
+			name:         "hours and whole days",
+			from:         day.Add(-time.Hour),
+			to:           day.AddDate(0, 0, 2).Add(2 * time.Hour),
+			wantRequests: 5,
+			wantVisits:   4, // hourly 23:00, daily day, daily next day, hourly 01:00
+		},
+		{
+			name:         "exactly one day",
+			from:         day,
+			to:           day.AddDate(0, 0, 1),
+			wantRequests: 2,
+			wantVisits:   1,
+		},
+		{
+			name:         "partial hours are widened",
+			from:         day.Add(30 * time.Minute),
+			to:           day.Add(12*time.Hour + 30*time.Minute),
+			wantRequests: 2,
+			wantVisits:   2,
+		},
+		{
+			name:         "end is exclusive",
+			from:         day.Add(time.Hour),
+			to:           day.Add(12 * time.Hour),
+			wantRequests: 0,
+			wantVisits:   0,
+		},
+		{
+			name: "empty range",
+			from: day,
+			to:   day,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, visits := 0, 0
+			err := ScanRollupRange(tt.from, tt.to, func(rollup UsageRollup) {
+				requests += rollup.Requests
+				visits++
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requests != tt.wantRequests || visits != tt.wantVisits {
+				t.Errorf("got %d requests in %d rollups, want %d in %d", requests, visits, tt.wantRequests, tt.wantVisits)
+			}
+		})
+	}
+}
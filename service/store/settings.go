@@ -0,0 +1,29 @@
+package store
+
+const settingsKey = "service"
+
+// Settings holds service-wide options
+type Settings struct {
+	// MetricsEnabled exposes Prometheus metrics at /metrics
+	MetricsEnabled bool `json:"metricsEnabled"`
+	// BindAddress is an extra address to listen on besides loopback, e.g. a
+	// LAN IP or 0.0.0.0. Empty keeps the service local. Applies on restart.
+	BindAddress string `json:"bindAddress,omitempty"`
+	// AllowedCIDRs lists the non-loopback clients allowed to connect
+	AllowedCIDRs []string `json:"allowedCidrs,omitempty"`
+}
+
+// GetSettings returns the saved settings, or the defaults if none were saved
+func GetSettings() (Settings, error) {
+	var settings Settings
+	count, err := SettingsBucket.Count()
+	if err != nil || count == 0 {
+		return settings, err
+	}
+	return SettingsBucket.Get(settingsKey)
+}
+
+// SaveSettings replaces the saved settings
+func SaveSettings(settings Settings) error {
+	return SettingsBucket.Put(settingsKey, settings)
+}
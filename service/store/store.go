@@ -106,11 +106,19 @@ func (b *Bucket[T]) Count() (int, error) {
 var (
 	Db *bbolt.DB
 
-	Users     Bucket[UserInfo]
-	Apps      Bucket[AppInfo]
-	LLMKeys   Bucket[LLMKey]
-	Usage     Bucket[TokenUsage]
-	Providers Bucket[[]byte]
+	Users        Bucket[UserInfo]
+	Apps         Bucket[AppInfo]
+	LLMKeys      Bucket[LLMKey]
+	Usage        Bucket[TokenUsage]
+	UsageRollups Bucket[UsageRollup]
+	Providers    Bucket[[]byte]
+	Pricing      Bucket[ModelPrice]
+
+	SettingsBucket Bucket[Settings]
+	SigningKeys    Bucket[SigningKey]
+	RevokedTokens  Bucket[RevokedToken]
+	AuthAudit      Bucket[AuthEvent]
+	KeyVaults      Bucket[KeyVault]
 )
 
 func Init(dbPath string) {
@@ -119,10 +127,85 @@ func Init(dbPath string) {
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
 	}
+	loadMasterKey(dbPath)
 
 	Users = InitBucket[UserInfo]("users")
 	Usage = InitBucket[TokenUsage]("usage")
+	UsageRollups = InitBucket[UsageRollup]("usage_rollups")
 	Apps = InitBucket[AppInfo]("apps")
 	LLMKeys = InitBucket[LLMKey]("llm_keys")
 	Providers = InitBucket[[]byte]("providers")
+	Pricing = InitBucket[ModelPrice]("model_pricing")
+	SettingsBucket = InitBucket[Settings]("settings")
+	SigningKeys = InitBucket[SigningKey]("signing_keys")
+	RevokedTokens = InitBucket[RevokedToken]("revoked_tokens")
+	AuthAudit = InitBucket[AuthEvent]("auth_audit")
+	KeyVaults = InitBucket[KeyVault]("key_vault")
+
+	rebuildRollupsIfMissing()
+	if err := loadKeyVault(); err != nil {
+		log.Fatal("Failed to load provider key vault:", err)
+	}
+	if err := ensureAdmin(); err != nil {
+		log.Println("Failed to assign an admin user:", err)
+	}
+	if err := pruneAuthEvents(); err != nil {
+		log.Println("Failed to prune auth audit events:", err)
+	}
+}
+
+// InternalBuckets hold credentials, or settings that are validated on save,
+// and must not be exposed through the generic store API
+var InternalBuckets = []string{"users", "signing_keys", "revoked_tokens", "auth_audit", "key_vault", "settings"}
+
+// OpenReadOnly opens a database file for inspection, without Init. Nothing is
+// created or migrated, and it fails rather than waiting while a service holds
+// the file.
+func OpenReadOnly(dbPath string) (*bbolt.DB, error) {
+	return bbolt.Open(dbPath, 0600, &bbolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+}
+
+// Inventory is what a database holds, as shown by `service status`
+type Inventory struct {
+	Users int
+	Apps  []AppInfo
+	Keys  []LLMKey
+}
+
+// ReadInventory reads the users, apps and keys of a database. Buckets that do
+// not exist yet count as empty.
+func ReadInventory(db *bbolt.DB) (Inventory, error) {
+	inventory := Inventory{Apps: []AppInfo{}, Keys: []LLMKey{}}
+	return inventory, db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte("users")); b != nil {
+			inventory.Users = b.Inspect().KeyN
+		}
+		if err := readAll(tx, "apps", &inventory.Apps); err != nil {
+			return err
+		}
+		return readAll(tx, "llm_keys", &inventory.Keys)
+	})
+}
+
+func readAll[T any](tx *bbolt.Tx, bucketName string, result *[]T) error {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		var data T
+		if err := json.Unmarshal(v, &data); err != nil {
+			return err
+		}
+		*result = append(*result, data)
+		return nil
+	})
+}
+
+// Close flushes and closes the database
+func Close() error {
+	if Db == nil {
+		return nil
+	}
+	return Db.Close()
 }
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestReadInventory(t *testing.T) {
+	// A database without buckets reads as empty
+	path := filepath.Join(t.TempDir(), "empty.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory, err := ReadInventory(db)
+	db.Close()
+	if err != nil || inventory.Users != 0 || len(inventory.Apps) != 0 || len(inventory.Keys) != 0 {
+		t.Errorf("got %+v, %v for an empty database", inventory, err)
+	}
+
+	initTestStore(t)
+	Users.Put("alice", UserInfo{Username: "alice"})
+	Apps.Put("a", AppInfo{ID: "a", Granted: true})
+	Apps.Put("b", AppInfo{ID: "b"})
+	LLMKeys.Put("k", LLMKey{ID: "k", BaseURL: "https://api.example.com"})
+	inventory, err = ReadInventory(Db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inventory.Users != 1 || len(inventory.Apps) != 2 || len(inventory.Keys) != 1 || inventory.Keys[0].BaseURL != "https://api.example.com" {
+		t.Errorf("unexpected inventory %+v", inventory)
+	}
+}
+
+func TestOpenReadOnlyWhileHeld(t *testing.T) {
+	initTestStore(t)
+	if _, err := OpenReadOnly(Db.Path()); err == nil {
+		t.Error("opened a database another handle holds for writing")
+	}
+}
@@ -1,27 +1,76 @@
 package store
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 type UserInfo struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username          string     `json:"username"`
+	Password          string     `json:"password"`
+	Admin             bool       `json:"admin,omitempty"`             // May register other users
+	SessionsNotBefore *time.Time `json:"sessionsNotBefore,omitempty"` // Sessions issued earlier are revoked
 }
 
 type LLMKey struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Type     string `json:"type"`     // "manual", "siliconflow", etc.
-	Protocol string `json:"protocol"` // "openai", "anthropic", etc.
-	BaseURL  string `json:"baseUrl"`
-	Token    string `json:"token"`
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Type            string     `json:"type"`     // "manual", "siliconflow", etc.
+	Protocol        string     `json:"protocol"` // "openai", "anthropic", etc.
+	BaseURL         string     `json:"baseUrl"`
+	Token           string     `json:"token"`                    // Plaintext only in memory, or for keys saved before encryption
+	EncryptedToken  string     `json:"encryptedToken,omitempty"` // Sealed with the vault data key
+	Budget          *Budget    `json:"budget,omitempty"`
+	RateLimit       *RateLimit `json:"rateLimit,omitempty"`
+	Models          []string   `json:"models,omitempty"` // Reported by the provider when the key was last tested
+	ModelsUpdatedAt *time.Time `json:"modelsUpdatedAt,omitempty"`
+	ModelsCheckedAt *time.Time `json:"modelsCheckedAt,omitempty"` // Last test, successful or not
+}
+
+// RateLimit caps the request rate of an app or key. Zero means unlimited.
+type RateLimit struct {
+	RequestsPerMinute int `json:"requestsPerMinute"`
+	TokensPerMinute   int `json:"tokensPerMinute"`
+	MaxConcurrent     int `json:"maxConcurrent"`
+	// How long a request may wait for capacity before it is rejected.
+	// Zero rejects immediately.
+	QueueTimeoutSeconds int `json:"queueTimeoutSeconds"`
+}
+
+// AppKeyRef references an LLMKey an app may use
+type AppKeyRef struct {
+	ID     string `json:"id"`
+	Weight int    `json:"weight,omitempty"` // Only used by the "weighted" strategy
+}
+
+// AppCaller identifies the executable and user that registered an app
+type AppCaller struct {
+	Executable string `json:"executable"`
+	UID        int    `json:"uid"`
+}
+
+// Key identifies the caller in AppInfo.CallerSecrets
+func (c AppCaller) Key() string {
+	return strconv.Itoa(c.UID) + ":" + c.Executable
 }
 
 type AppInfo struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Description  string    `json:"description"`
-	Key          string    `json:"key"`
-	Granted      bool      `json:"granted"`
-	CreatedAt    time.Time `json:"createdAt"`
-	LastActiveAt time.Time `json:"lastActiveAt"`
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	Key           string            `json:"key"`
+	Keys          []AppKeyRef       `json:"keys,omitempty"`        // Takes precedence over Key when set
+	KeyStrategy   string            `json:"keyStrategy,omitempty"` // "failover" (default) or "weighted"
+	Budget        *Budget           `json:"budget,omitempty"`
+	RateLimit     *RateLimit        `json:"rateLimit,omitempty"`
+	AllowedModels []string          `json:"allowedModels,omitempty"` // Model name patterns, empty allows all
+	ModelAliases  map[string]string `json:"modelAliases,omitempty"`  // Alias to upstream model name
+	Granted       bool              `json:"granted"`
+	Caller        *AppCaller        `json:"caller,omitempty"`        // Last process that registered over the Unix socket
+	PinExecutable bool              `json:"pinExecutable,omitempty"` // Re-prompt registrations from other executables or users
+	SecretHash    string            `json:"secretHash,omitempty"`    // SHA-256 of the gateway secret not tied to a caller, empty when none is issued
+	CallerSecrets map[string]string `json:"callerSecrets,omitempty"` // Caller key to secret hash, one per executable registered over the Unix socket
+	SecretIssued  *time.Time        `json:"secretIssued,omitempty"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	LastActiveAt  time.Time         `json:"lastActiveAt"`
 }
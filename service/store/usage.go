@@ -1,13 +1,21 @@
 package store
 
 import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.etcd.io/bbolt"
 )
 
 // TokenUsage represents a token usage record
 type TokenUsage struct {
+	ID           string    `json:"id,omitempty"` // Filled from the record key when read
 	AppID        string    `json:"appId"`
 	AppName      string    `json:"appName"`
+	KeyID        string    `json:"keyId"`
 	Key          string    `json:"key"`
 	Model        string    `json:"model"`
 	PromptTokens int       `json:"promptTokens"`
@@ -28,6 +36,8 @@ type UsageStats struct {
 	ByKey         map[string]KeyUsage   `json:"byKey"`
 	ByModel       map[string]ModelUsage `json:"byModel"`
 	RecentUsages  []TokenUsage          `json:"recentUsages"`
+	Series        []UsagePoint          `json:"series"`
+	Budgets       *BudgetStates         `json:"budgets,omitempty"`
 }
 
 type AppUsage struct {
@@ -50,100 +60,252 @@ type ModelUsage struct {
 	RequestCount int     `json:"requestCount"`
 }
 
-// GetUsageStats calculates and returns usage statistics
-func GetUsageStats(days int) (*UsageStats, error) {
-	usages, err := Usage.List()
+// UsagePoint is one interval of a usage time series
+type UsagePoint struct {
+	Time         time.Time `json:"time"`
+	TotalTokens  int       `json:"totalTokens"`
+	TotalCost    float64   `json:"totalCost"`
+	RequestCount int       `json:"requestCount"`
+	ErrorCount   int       `json:"errorCount"`
+}
+
+const recentUsageLimit = 100
+
+// GetUsageStats calculates usage statistics for [from, to) from the rollups,
+// along with a time series at the given interval ("hour" or "day")
+func GetUsageStats(from, to time.Time, interval string) (*UsageStats, error) {
+	stats := &UsageStats{
+		ByApp:   make(map[string]AppUsage),
+		ByKey:   make(map[string]KeyUsage),
+		ByModel: make(map[string]ModelUsage),
+	}
+
+	err := ScanRollupRange(from, to, func(rollup UsageRollup) {
+		stats.TotalTokens += rollup.TotalTokens
+		stats.TotalCost += rollup.Cost
+		stats.TotalRequests += rollup.Requests
+
+		// By App
+		appUsage := stats.ByApp[rollup.AppID]
+		appUsage.AppName = rollup.AppName
+		appUsage.TotalTokens += rollup.TotalTokens
+		appUsage.TotalCost += rollup.Cost
+		appUsage.RequestCount += rollup.Requests
+		stats.ByApp[rollup.AppID] = appUsage
+
+		// By Key
+		keyUsage := stats.ByKey[rollup.Key]
+		keyUsage.TotalTokens += rollup.TotalTokens
+		keyUsage.TotalCost += rollup.Cost
+		keyUsage.RequestCount += rollup.Requests
+		stats.ByKey[rollup.Key] = keyUsage
+
+		// By Model
+		modelKey := rollup.Key + "/" + rollup.Model
+		modelUsage := stats.ByModel[modelKey]
+		modelUsage.Key = rollup.Key
+		modelUsage.TotalTokens += rollup.TotalTokens
+		modelUsage.TotalCost += rollup.Cost
+		modelUsage.RequestCount += rollup.Requests
+		stats.ByModel[modelKey] = modelUsage
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	cutoff := time.Now().AddDate(0, 0, -days)
-	stats := &UsageStats{
-		ByApp:        make(map[string]AppUsage),
-		ByKey:        make(map[string]KeyUsage),
-		ByModel:      make(map[string]ModelUsage),
-		RecentUsages: make([]TokenUsage, 0, len(usages)),
+	stats.Series, err = getUsageSeries(from, to, interval)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, usage := range usages {
-		if usage.Timestamp.Before(cutoff) {
-			continue
-		}
+	stats.RecentUsages, _, err = QueryUsage(UsageFilter{From: from, To: to}, "", recentUsageLimit)
+	if err != nil {
+		return nil, err
+	}
 
-		stats.TotalTokens += usage.TotalTokens
-		stats.TotalCost += usage.Cost
-		stats.TotalRequests++
+	return stats, nil
+}
 
-		// By App
-		if appUsage, exists := stats.ByApp[usage.AppID]; exists {
-			appUsage.TotalTokens += usage.TotalTokens
-			appUsage.TotalCost += usage.Cost
-			appUsage.RequestCount++
-			stats.ByApp[usage.AppID] = appUsage
-		} else {
-			stats.ByApp[usage.AppID] = AppUsage{
-				AppName:      usage.AppName,
-				TotalTokens:  usage.TotalTokens,
-				TotalCost:    usage.Cost,
-				RequestCount: 1,
-			}
+// getUsageSeries returns one point per interval overlapping [from, to), with
+// empty intervals included so charts have no gaps
+func getUsageSeries(from, to time.Time, interval string) ([]UsagePoint, error) {
+	if interval != RollupDaily {
+		interval = RollupHourly
+	}
+	start := truncatePeriod(from.Local(), interval)
+	end := nextPeriod(to.Local().Add(-time.Nanosecond), interval)
+
+	points := make(map[time.Time]*UsagePoint)
+	err := ScanRollups(interval, start, end, func(rollup UsageRollup) {
+		period := truncatePeriod(rollup.Period.Local(), interval)
+		point, ok := points[period]
+		if !ok {
+			point = &UsagePoint{Time: period}
+			points[period] = point
 		}
+		point.TotalTokens += rollup.TotalTokens
+		point.TotalCost += rollup.Cost
+		point.RequestCount += rollup.Requests
+		point.ErrorCount += rollup.Errors
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// By Key
-		if keyUsage, exists := stats.ByKey[usage.Key]; exists {
-			keyUsage.TotalTokens += usage.TotalTokens
-			keyUsage.TotalCost += usage.Cost
-			keyUsage.RequestCount++
-			stats.ByKey[usage.Key] = keyUsage
+	series := make([]UsagePoint, 0)
+	for period := start; period.Before(end); period = nextPeriod(period, interval) {
+		if point, ok := points[period]; ok {
+			series = append(series, *point)
 		} else {
-			stats.ByKey[usage.Key] = KeyUsage{
-				TotalTokens:  usage.TotalTokens,
-				TotalCost:    usage.Cost,
-				RequestCount: 1,
-			}
+			series = append(series, UsagePoint{Time: period})
 		}
+	}
+	return series, nil
+}
 
-		// By Model
-		modelKey := usage.Key + "/" + usage.Model
-		if modelUsage, exists := stats.ByModel[modelKey]; exists {
-			modelUsage.TotalTokens += usage.TotalTokens
-			modelUsage.TotalCost += usage.Cost
-			modelUsage.RequestCount++
-			stats.ByModel[modelKey] = modelUsage
+// UsageFilter selects usage records. Empty fields match everything.
+type UsageFilter struct {
+	AppID    string
+	Key      string // Key ID or key name
+	Model    string
+	Status   string
+	Endpoint string
+	From     time.Time
+	To       time.Time
+}
+
+// Match reports whether a record passes the filter
+func (f UsageFilter) Match(usage TokenUsage) bool {
+	return (f.AppID == "" || usage.AppID == f.AppID) &&
+		(f.Key == "" || usage.KeyID == f.Key || usage.Key == f.Key) &&
+		(f.Model == "" || usage.Model == f.Model) &&
+		(f.Status == "" || usage.Status == f.Status) &&
+		(f.Endpoint == "" || usage.Endpoint == f.Endpoint) &&
+		(f.From.IsZero() || !usage.Timestamp.Before(f.From)) &&
+		(f.To.IsZero() || usage.Timestamp.Before(f.To))
+}
+
+// QueryUsage returns up to limit matching records, most recent first, starting
+// after the record with the given cursor ID. The returned cursor is empty when
+// there are no more records.
+func QueryUsage(filter UsageFilter, cursor string, limit int) ([]TokenUsage, string, error) {
+	result := make([]TokenUsage, 0)
+	next := ""
+	err := ScanUsage(filter, cursor, func(usage TokenUsage) bool {
+		if len(result) == limit {
+			next = result[len(result)-1].ID
+			return false
+		}
+		result = append(result, usage)
+		return true
+	})
+	return result, next, err
+}
+
+// QueryUsagePage returns one page of matching records, oldest first, and the
+// number of matching records. It serves clients written for the page-based
+// list API and reads every matching record, so prefer QueryUsage.
+func QueryUsagePage(filter UsageFilter, page, pageSize int) ([]TokenUsage, int, error) {
+	result := make([]TokenUsage, 0)
+	start := (page - 1) * pageSize
+	total := 0
+	err := ScanUsageForward(filter, func(usage TokenUsage) bool {
+		if total >= start && len(result) < pageSize {
+			result = append(result, usage)
+		}
+		total++
+		return true
+	})
+	return result, total, err
+}
+
+// ScanUsage visits matching records, most recent first, starting after the
+// record with the given cursor ID, until visit returns false. Record keys begin
+// with their creation time, so only the requested time range is read.
+func ScanUsage(filter UsageFilter, cursor string, visit func(TokenUsage) bool) error {
+	return Db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(Usage.bucketName)).Cursor()
+
+		// Position on the last record before the upper bound
+		var k, v []byte
+		var upper []byte
+		if cursor != "" {
+			upper = []byte(cursor)
+		} else if !filter.To.IsZero() {
+			// Keys only have second precision, so records from the final second are included
+			upper = []byte(filter.To.Local().Add(time.Second).Format(usageIDTimeFormat))
+		}
+		if upper == nil {
+			k, v = c.Last()
+		} else if k, v = c.Seek(upper); k == nil {
+			k, v = c.Last()
 		} else {
-			stats.ByModel[modelKey] = ModelUsage{
-				Key:          usage.Key,
-				TotalTokens:  usage.TotalTokens,
-				TotalCost:    usage.Cost,
-				RequestCount: 1,
-			}
+			k, v = c.Prev()
 		}
 
-		stats.RecentUsages = append(stats.RecentUsages, usage)
-	}
+		var start []byte
+		if !filter.From.IsZero() {
+			start = []byte(filter.From.Local().Format(usageIDTimeFormat))
+		}
 
-	// Sort recent usages by timestamp (most recent first)
-	for i := 0; i < len(stats.RecentUsages)-1; i++ {
-		for j := i + 1; j < len(stats.RecentUsages); j++ {
-			if stats.RecentUsages[i].Timestamp.Before(stats.RecentUsages[j].Timestamp) {
-				stats.RecentUsages[i], stats.RecentUsages[j] = stats.RecentUsages[j], stats.RecentUsages[i]
+		for ; k != nil && bytes.Compare(k, start) >= 0; k, v = c.Prev() {
+			var usage TokenUsage
+			if err := json.Unmarshal(v, &usage); err != nil {
+				return err
+			}
+			usage.ID = string(k)
+			if !filter.Match(usage) {
+				continue
+			}
+			if !visit(usage) {
+				break
 			}
 		}
-	}
+		return nil
+	})
+}
 
-	// Limit to last 100 records
-	if len(stats.RecentUsages) > 100 {
-		stats.RecentUsages = stats.RecentUsages[:100]
-	}
+// ScanUsageForward visits matching records in chronological order until
+// visit returns false
+func ScanUsageForward(filter UsageFilter, visit func(TokenUsage) bool) error {
+	return Db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(Usage.bucketName)).Cursor()
 
-	return stats, nil
+		var k, v []byte
+		if filter.From.IsZero() {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek([]byte(filter.From.Local().Format(usageIDTimeFormat)))
+		}
+
+		var end []byte
+		if !filter.To.IsZero() {
+			end = []byte(filter.To.Local().Add(time.Second).Format(usageIDTimeFormat))
+		}
+
+		for ; k != nil && (end == nil || bytes.Compare(k, end) < 0); k, v = c.Next() {
+			var usage TokenUsage
+			if err := json.Unmarshal(v, &usage); err != nil {
+				return err
+			}
+			usage.ID = string(k)
+			if !filter.Match(usage) {
+				continue
+			}
+			if !visit(usage) {
+				break
+			}
+		}
+		return nil
+	})
 }
 
-// RecordUsage records a new token usage
-func RecordUsage(appID, appName, key, model, endpoint string, promptTokens, outputTokens int, cost float64, status string) error {
+// RecordUsage records a new token usage and adds it to the rollups
+func RecordUsage(appID, appName, keyID, key, model, endpoint string, promptTokens, outputTokens int, cost float64, status string) error {
 	usage := TokenUsage{
 		AppID:        appID,
 		AppName:      appName,
+		KeyID:        keyID,
 		Key:          key,
 		Model:        model,
 		PromptTokens: promptTokens,
@@ -155,19 +317,43 @@ func RecordUsage(appID, appName, key, model, endpoint string, promptTokens, outp
 		Status:       status,
 	}
 
-	id := generateID()
-	return Usage.Put(id, usage)
-}
+	v, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	return Db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(Usage.bucketName))
+
+		// Coarse clocks can repeat timestamps, so step forward to a free key
+		at := usage.Timestamp
+		id := generateID(at)
+		for b.Get([]byte(id)) != nil {
+			at = at.Add(time.Nanosecond)
+			id = generateID(at)
+		}
 
-func generateID() string {
-	return time.Now().Format("20060102150405") + generateRandomString(6)
+		if err := b.Put([]byte(id), v); err != nil {
+			return err
+		}
+		return addToRollups(tx, usage)
+	})
 }
 
-func generateRandomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	result := make([]byte, length)
-	for i := range result {
-		result[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+// ClearUsage deletes all usage records and rollups
+func ClearUsage() error {
+	if err := Usage.Clear(); err != nil {
+		return err
 	}
-	return string(result)
+	return UsageRollups.Clear()
+}
+
+const usageIDTimeFormat = "20060102150405"
+
+// generateID builds a record key from its creation time. The second is
+// followed by the nanoseconds in base 36, so keys sort by time and keep the
+// same length as keys with a random suffix written by earlier versions.
+func generateID(t time.Time) string {
+	nanos := strconv.FormatInt(int64(t.Nanosecond()), 36)
+	return t.Format(usageIDTimeFormat) + strings.Repeat("0", 6-len(nanos)) + nanos
 }
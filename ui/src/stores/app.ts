@@ -1,33 +1,49 @@
+import type { Budget, RateLimit } from './keys'
 import { defineStore } from 'pinia'
 import { computed, ref } from 'vue'
 import { toast } from 'vue-sonner'
 import { useI18n } from '@/lib/locals'
 import { useAppsDb } from './db'
+import { useServiceStore } from './service'
 
 export interface App {
   id: string
   name: string
   description?: string
   key: string
+  keys?: { id: string, weight?: number }[]
+  keyStrategy?: 'failover' | 'weighted'
+  budget?: Budget
+  rateLimit?: RateLimit
+  allowedModels?: string[]
+  modelAliases?: Record<string, string>
   granted: boolean
+  caller?: { executable: string, uid: number }
+  pinExecutable?: boolean
+  secretIssued?: string
   createdAt: string
   lastActiveAt: string
 }
 
 export const useAppStore = defineStore('app', () => {
   const db = useAppsDb()
+  const serviceStore = useServiceStore()
   const { t } = useI18n({
     'zh-CN': {
       appDeleted: '应用已删除',
       allAppsDeleted: '所有应用已删除',
       appAuthorized: '应用已授权',
       appAuthorizationRevoked: '应用授权已撤销',
+      appSecretRevoked: '应用密钥已吊销',
+      appDenied: '已拒绝应用请求',
     },
     'en-US': {
       appDeleted: 'Application deleted',
       allAppsDeleted: 'All applications deleted',
       appAuthorized: 'Application authorized',
       appAuthorizationRevoked: 'Application authorization revoked',
+      appSecretRevoked: 'Application secret revoked',
+      appDenied: 'Application request denied',
     },
   })
 
@@ -63,7 +79,7 @@ export const useAppStore = defineStore('app', () => {
     await loadApps()
   }
 
-  const toggleAppAuthorization = async (id: string, granted: boolean, key?: string) => {
+  const toggleAppAuthorization = async (id: string, granted: boolean, key?: string, pinExecutable?: boolean) => {
     try {
       const app = await db.get(id)
       if (!app) {
@@ -74,6 +90,9 @@ export const useAppStore = defineStore('app', () => {
       if (granted && key) {
         app.key = key
       }
+      if (granted && pinExecutable !== undefined) {
+        app.pinExecutable = pinExecutable
+      }
       await db.put(id, app)
       const appIndex = apps.value.findIndex(app => app.id === id)
       if (appIndex !== -1) {
@@ -89,6 +108,36 @@ export const useAppStore = defineStore('app', () => {
     }
   }
 
+  // Answers a pending registration, which then receives a new app secret
+  const grantApp = async (id: string, granted: boolean, key?: string, pinExecutable?: boolean) => {
+    const resp = await serviceStore.api('app/grant', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ id, granted, key, pinExecutable }),
+    })
+    if (!resp.ok) {
+      const data = await resp.json().catch(() => ({}))
+      toast.error(data.error || 'Operation failed')
+      throw new Error(data.error || 'Operation failed')
+    }
+    toast.success(granted ? t('appAuthorized') : t('appDenied'))
+  }
+
+  const revokeAppSecret = async (id: string) => {
+    const resp = await serviceStore.api('app/revoke-secret', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ id }),
+    })
+    if (!resp.ok) {
+      const data = await resp.json().catch(() => ({}))
+      toast.error(data.error || 'Operation failed')
+      throw new Error(data.error || 'Operation failed')
+    }
+    updateApp(id, { secretIssued: undefined })
+    toast.success(t('appSecretRevoked'))
+  }
+
   const deleteApp = async (id: string) => {
     try {
       await db.delete(id)
@@ -150,6 +199,8 @@ export const useAppStore = defineStore('app', () => {
     loadApps,
     refreshApps,
     toggleAppAuthorization,
+    grantApp,
+    revokeAppSecret,
     deleteApp,
     deleteAllApps,
     clearError,
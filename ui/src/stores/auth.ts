@@ -97,6 +97,15 @@ export const useAuthStore = defineStore('auth', () => {
   }
 
   async function logout() {
+    if (serviceStore.token) {
+      try {
+        // Revoke the session on the service, not just locally
+        await serviceStore.api('auth/logout', { method: 'POST' })
+      }
+      catch (error) {
+        console.error('Logout failed:', error)
+      }
+    }
     currentUser.value = null
     serviceStore.token = null
     savedUsername.value = ''
@@ -1,5 +1,5 @@
 export { type App, useAppStore } from './app'
 export { type AuthState, useAuthStore } from './auth'
-export { type APIKey, useKeysStore } from './keys'
+export { type APIKey, type KeyTestResult, useKeysStore } from './keys'
 export { useServiceStore } from './service'
 export { type Theme, useThemeStore } from './theme'
@@ -4,28 +4,58 @@ import { ref } from 'vue'
 import { toast } from 'vue-sonner'
 import { useI18n } from '@/lib/locals'
 import { useKeysDb } from './db'
+import { useServiceStore } from './service'
+
+export interface Budget {
+  dailyTokens: number
+  monthlyTokens: number
+  dailyCost: number
+  monthlyCost: number
+}
+
+export interface RateLimit {
+  requestsPerMinute: number
+  tokensPerMinute: number
+  maxConcurrent: number
+  queueTimeoutSeconds: number
+}
 
 export interface APIKey {
   id: string
   name: string
   type: string
-  protocol: 'openai'
+  protocol: 'openai' | 'anthropic'
   baseUrl: string
   token: string
+  budget?: Budget
+  rateLimit?: RateLimit
+  models?: string[]
+  modelsUpdatedAt?: string
+}
+
+export interface KeyTestResult {
+  ok: boolean
+  statusCode?: number
+  error?: string
+  latencyMs: number
+  models: string[]
 }
 
 export const useKeysStore = defineStore('keys', () => {
   const db = useKeysDb()
+  const serviceStore = useServiceStore()
   const { t } = useI18n({
     'en-US': {
       addKeyFailed: 'Failed to add key',
       updateKeyFailed: 'Failed to update key',
       deleteKeyFailed: 'Failed to delete key',
+      keyTestFailed: 'Key check failed: {error}',
     },
     'zh-CN': {
       addKeyFailed: '添加密钥失败',
       updateKeyFailed: '更新密钥失败',
       deleteKeyFailed: '删除密钥失败',
+      keyTestFailed: '密钥检查失败：{error}',
     },
   })
 
@@ -70,6 +100,8 @@ export const useKeysStore = defineStore('keys', () => {
     try {
       await db.put(id, data)
       await loadKeys()
+      // Check the new key in the background so problems show up right away
+      testKey(id).catch(() => {})
       return data
     }
     catch (err) {
@@ -82,6 +114,7 @@ export const useKeysStore = defineStore('keys', () => {
     try {
       await db.put(keyId, key)
       await loadKeys()
+      testKey(keyId).catch(() => {})
     }
     catch (err) {
       toast.error(t('updateKeyFailed'))
@@ -89,6 +122,23 @@ export const useKeysStore = defineStore('keys', () => {
     }
   }
 
+  async function testKey(keyId: string): Promise<KeyTestResult> {
+    const resp = await serviceStore.api(`keys/${keyId}/test`, { method: 'POST' })
+    const data = await resp.json().catch(() => ({}))
+    if (!resp.ok) {
+      toast.error(data.error || 'Operation failed')
+      throw new Error(data.error || 'Operation failed')
+    }
+    const result = data.data as KeyTestResult
+    if (result.ok) {
+      await loadKeys()
+    }
+    else {
+      toast.error(t('keyTestFailed', { error: result.error }))
+    }
+    return result
+  }
+
   async function deleteKey(keyId: string) {
     try {
       await db.delete(keyId)
@@ -110,6 +160,7 @@ export const useKeysStore = defineStore('keys', () => {
     createAndAddKey,
     addKey,
     updateKey,
+    testKey,
     deleteKey,
   }
 })